@@ -0,0 +1,143 @@
+/* Copyright 2022 Cognite AS */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/bes"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/collecter"
+)
+
+type besServerCmd struct {
+	cc     *collectCmd // for the cache flags
+	listen string
+	outDir string
+
+	cmd *cobra.Command
+}
+
+func newBESServerCmd() *besServerCmd {
+	cmd := &cobra.Command{
+		Use:   "bes-server",
+		Short: "Collect snapshots from builds streaming their build events",
+		Long: `Runs a Build Event Service, which Bazel can stream its build events to with
+	--bes_backend=grpc://<host>:<port>. Collects a snapshot from every build
+	which finishes streaming its events, like collect does. The builds must
+	include the 'change_track_files' output group, e.g. with
+	--output_groups=+change_track_files.`,
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	bc := &besServerCmd{
+		cc:  &collectCmd{},
+		cmd: cmd,
+	}
+
+	cmd.PersistentFlags().StringVar(&bc.cc.workspacePath, "workspace-path", "", "workspace path")
+	cmd.PersistentFlags().StringVar(&bc.listen, "listen", ":8980", "address to receive build events on")
+	cmd.PersistentFlags().StringVar(&bc.outDir, "out-dir", "", "directory to write the snapshots to, as <invocation id>.json; prints them if not set")
+	cmd.PersistentFlags().BoolVar(&bc.cc.keepGoing, "keep-going", false, "record targets which failed to build as unknown, instead of not collecting a snapshot")
+	bc.cc.addCacheFlags(cmd)
+
+	cmd.RunE = bc.runBESServer
+
+	return bc
+}
+
+func (bc *besServerCmd) checkArgs() error {
+	if err := bc.cc.checkCacheArgs(); err != nil {
+		return err
+	}
+
+	if bc.outDir != "" && !path.IsAbs(bc.outDir) {
+		bc.outDir = path.Join(bc.cc.workspacePath, bc.outDir)
+	}
+
+	return nil
+}
+
+func (bc *besServerCmd) runBESServer(cmd *cobra.Command, args []string) error {
+	if err := bc.checkArgs(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	srv, err := startBESServer(bc.listen)
+	if err != nil {
+		return err
+	}
+	defer srv.Stop()
+
+	for {
+		inv, err := srv.Next(ctx)
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("received build events for invocation %s", inv.InvocationID)
+
+		collectArgs := bc.cc.collectArgs()
+		collectArgs.BuildEvents = inv.Events()
+		// the builds aren't necessarily of the server's checkout
+		collectArgs.SkipGitMetadata = true
+		if bc.outDir != "" {
+			collectArgs.OutPath = path.Join(bc.outDir, inv.InvocationID+".json")
+		}
+
+		// a failing build shouldn't stop the server
+		if _, err := collecter.NewCollecter().Collect(&collectArgs); err != nil {
+			log.Printf("failed to collect invocation %s: %v", inv.InvocationID, err)
+		}
+	}
+}
+
+// startBESServer starts a Build Event Service listening on addr.
+func startBESServer(addr string) (*bes.Server, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	srv := bes.NewServer()
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			log.Printf("build event service stopped: %v", err)
+		}
+	}()
+	log.Printf("receiving build events on %s", lis.Addr())
+
+	return srv, nil
+}
+
+// receiveBuildEvents runs a Build Event Service on addr until it has
+// received the build events of a single invocation.
+func receiveBuildEvents(ctx context.Context, addr string) (*bes.Invocation, error) {
+	srv, err := startBESServer(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer srv.Stop()
+
+	inv, err := srv.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("received build events for invocation %s", inv.InvocationID)
+
+	return inv, nil
+}
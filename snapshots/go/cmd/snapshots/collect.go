@@ -3,30 +3,41 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
 	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/cache"
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/collecter"
 )
 
 type collectCmd struct {
 	bazelCacheGrpcInsecure bool
 	bazelCacheGrpcMetadata []string
+	bazelCacheHTTPURL      string
+	bazelCacheHTTPOptions  cache.HTTPClientOptions
 	bazelPath              string
 	bazelQueryExpression   string
 	bazelRcPath            string
 	bazelStderr            bool
+	besListen              string
 	buildEventsPath        string
-	credentialHelper       string
+	buildEventsBinaryPath  string
+	credentialHelpers      []string
+	jobs                   int
+	keepGoing              bool
+	base                   string
 	outPath                string
 	noPrint                bool
 	workspacePath          string
+	storageURL             string
 
 	cmd *cobra.Command
 }
@@ -53,12 +64,14 @@ func newCollectCmd() *collectCmd {
 	cmd.PersistentFlags().StringVar(&cc.workspacePath, "workspace-path", "", "workspace path")
 
 	// collect flags
-	cmd.PersistentFlags().BoolVar(&cc.bazelCacheGrpcInsecure, "bazel_cache_grpc_insecure", false, "use insecure connection for grpc bazel cache")
-	cmd.PersistentFlags().StringArrayVar(&cc.bazelCacheGrpcMetadata, "bazel_cache_grpc_metadata", []string{}, "add metadata to connection for grpc bazel cache")
+	cc.addCacheFlags(cmd)
 	cmd.PersistentFlags().StringVar(&cc.bazelQueryExpression, "bazel-query", "//...", "the bazel query expression to consider")
+	cmd.PersistentFlags().StringVar(&cc.besListen, "bes-listen", "", "address to receive the build events on as a Build Event Service (see Bazel's --bes_backend), instead of running bazel")
 	cmd.PersistentFlags().StringVar(&cc.buildEventsPath, "build_event_json_file", "", "a bazel build event json file")
+	cmd.PersistentFlags().StringVar(&cc.buildEventsBinaryPath, "build_event_binary_file", "", "a bazel build event binary (delimited protobuf) file")
 	cmd.PersistentFlags().BoolVar(&cc.bazelStderr, "bazel-stderr", false, "show stderr from bazel")
-	cmd.PersistentFlags().StringVar(&cc.credentialHelper, "credential_helper", "", "path to a credential helper, relative to workspace-path")
+	cmd.PersistentFlags().BoolVar(&cc.keepGoing, "keep-going", false, "record targets which failed to build as unknown, instead of failing")
+	cmd.PersistentFlags().StringVar(&cc.base, "base", "", "snapshot or tag to carry forward the trackers of targets which failed to build from; ignored without --keep-going")
 	cmd.PersistentFlags().StringVar(&cc.outPath, "out-path", "", "output file path")
 	cmd.PersistentFlags().BoolVar(&cc.noPrint, "no-print", false, "don't print if not writing to file")
 
@@ -67,8 +80,22 @@ func newCollectCmd() *collectCmd {
 	return cc
 }
 
+// addCacheFlags adds the flags for retrieving change trackers from Bazel's
+// caches, which are shared with bes-server.
+func (cc *collectCmd) addCacheFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVar(&cc.bazelCacheGrpcInsecure, "bazel_cache_grpc_insecure", false, "use insecure connection for grpc bazel cache")
+	cmd.PersistentFlags().StringArrayVar(&cc.bazelCacheGrpcMetadata, "bazel_cache_grpc_metadata", []string{}, "add metadata to connection for grpc bazel cache")
+	cmd.PersistentFlags().StringVar(&cc.bazelCacheHTTPURL, "bazel_cache_http_url", "", "base url of an http bazel cache, to retrieve outputs from by digest")
+	cmd.PersistentFlags().StringVar(&cc.bazelCacheHTTPOptions.TLSCertificate, "bazel_cache_http_tls_certificate", "", "CA certificates to verify the http bazel cache with, relative to workspace-path")
+	cmd.PersistentFlags().StringVar(&cc.bazelCacheHTTPOptions.TLSClientCertificate, "bazel_cache_http_tls_client_certificate", "", "client certificate for the http bazel cache, relative to workspace-path")
+	cmd.PersistentFlags().StringVar(&cc.bazelCacheHTTPOptions.TLSClientKey, "bazel_cache_http_tls_client_key", "", "client key for the http bazel cache, relative to workspace-path")
+	cmd.PersistentFlags().BoolVar(&cc.bazelCacheHTTPOptions.TLSInsecureSkipVerify, "bazel_cache_http_tls_insecure_skip_verify", false, "don't verify the certificate of the http bazel cache")
+	cmd.PersistentFlags().StringArrayVar(&cc.credentialHelpers, "credential_helper", nil, "credential helper as [<host-pattern>=]<path>, relative to workspace-path (see Bazel's --credential_helper); can be repeated")
+	cmd.PersistentFlags().IntVar(&cc.jobs, "jobs", defaultJobs, "number of change trackers to retrieve concurrently")
+}
+
 func (cc *collectCmd) checkArgs() error {
-	if cc.bazelPath == "" {
+	if cc.bazelPath == "" && cc.besListen == "" {
 		path, err := exec.LookPath("bazel")
 		if err != nil {
 			return err
@@ -76,6 +103,45 @@ func (cc *collectCmd) checkArgs() error {
 		cc.bazelPath = path
 	}
 
+	if err := cc.checkCacheArgs(); err != nil {
+		return err
+	}
+
+	storageURL, err := cc.cmd.Flags().GetString("storage-url")
+	if err != nil {
+		return err
+	}
+	cc.storageURL = storageURL
+
+	sources := 0
+	for _, source := range []string{cc.besListen, cc.buildEventsPath, cc.buildEventsBinaryPath} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("only one of --bes-listen, --build_event_json_file and --build_event_binary_file can be used")
+	}
+
+	if cc.base != "" && !cc.keepGoing {
+		log.Printf("ignoring --base, as trackers are only carried forward with --keep-going")
+		cc.base = ""
+	}
+
+	if cc.outPath != "" && !path.IsAbs(cc.outPath) {
+		cc.outPath = path.Join(cc.workspacePath, cc.outPath)
+	}
+
+	if cc.bazelRcPath != "" && !path.IsAbs(cc.bazelRcPath) {
+		cc.bazelRcPath = path.Join(cc.workspacePath, cc.bazelRcPath)
+	}
+
+	return nil
+}
+
+// checkCacheArgs checks the workspace path and the flags added by
+// addCacheFlags.
+func (cc *collectCmd) checkCacheArgs() error {
 	if cc.workspacePath == "" {
 		if wsDir := os.Getenv("BUILD_WORKSPACE_DIRECTORY"); wsDir != "" {
 			cc.workspacePath = wsDir
@@ -84,12 +150,18 @@ func (cc *collectCmd) checkArgs() error {
 		}
 	}
 
-	if cc.outPath != "" && !path.IsAbs(cc.outPath) {
-		cc.outPath = path.Join(cc.workspacePath, cc.outPath)
+	if cc.jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1: %d", cc.jobs)
 	}
 
-	if cc.bazelRcPath != "" && !path.IsAbs(cc.bazelRcPath) {
-		cc.bazelRcPath = path.Join(cc.workspacePath, cc.bazelRcPath)
+	for _, p := range []*string{
+		&cc.bazelCacheHTTPOptions.TLSCertificate,
+		&cc.bazelCacheHTTPOptions.TLSClientCertificate,
+		&cc.bazelCacheHTTPOptions.TLSClientKey,
+	} {
+		if *p != "" && !path.IsAbs(*p) {
+			*p = path.Join(cc.workspacePath, *p)
+		}
 	}
 
 	for _, md := range cc.bazelCacheGrpcMetadata {
@@ -113,22 +185,50 @@ func (cc *collectCmd) runCollect(cmd *cobra.Command, args []string) error {
 	log.Println("query expression:", cc.bazelQueryExpression)
 	log.Println("out path:        ", cc.outPath)
 
-	collectArgs := collecter.CollectArgs{
+	collectArgs := cc.collectArgs()
+	if cc.base != "" {
+		base, err := resolveSnapshot(context.Background(), cc.storageURL, cc.base)
+		if err != nil {
+			return fmt.Errorf("failed to get base snapshot %s: %w", cc.base, err)
+		}
+		collectArgs.BaseSnapshot = base
+	}
+
+	if cc.besListen != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		inv, err := receiveBuildEvents(ctx, cc.besListen)
+		if err != nil {
+			return fmt.Errorf("failed to receive build events: %w", err)
+		}
+		collectArgs.BuildEvents = inv.Events()
+	}
+
+	if _, err := collecter.NewCollecter().Collect(&collectArgs); err != nil {
+		return fmt.Errorf("failed to collect: %w", err)
+	}
+
+	return nil
+}
+
+func (cc *collectCmd) collectArgs() collecter.CollectArgs {
+	return collecter.CollectArgs{
 		BazelCacheGrpcs:        !cc.bazelCacheGrpcInsecure,
 		BazelCacheGrpcMetadata: cc.bazelCacheGrpcMetadata,
+		BazelCacheHTTPURL:      cc.bazelCacheHTTPURL,
+		BazelCacheHTTPOptions:  cc.bazelCacheHTTPOptions,
 		BazelExpression:        cc.bazelQueryExpression,
 		BazelPath:              cc.bazelPath,
 		BazelRcPath:            cc.bazelRcPath,
 		BazelWorkspacePath:     cc.workspacePath,
 		BazelWriteStderr:       cc.bazelStderr,
 		BazelBuildEventsPath:   cc.buildEventsPath,
-		CredentialHelper:       cc.credentialHelper,
+		BuildEventsBinaryPath:  cc.buildEventsBinaryPath,
+		CredentialHelpers:      cc.credentialHelpers,
+		Jobs:                   cc.jobs,
 		OutPath:                cc.outPath,
 		NoPrint:                cc.noPrint,
+		KeepGoing:              cc.keepGoing,
 	}
-	if _, err := collecter.NewCollecter().Collect(&collectArgs); err != nil {
-		return fmt.Errorf("failed to collect: %w", err)
-	}
-
-	return nil
 }
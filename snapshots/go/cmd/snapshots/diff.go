@@ -4,29 +4,36 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"os/exec"
 	"path"
+	"strings"
+	"text/template"
 
 	"github.com/spf13/cobra"
 
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/cache"
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/differ"
-	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/getter"
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
-	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
 )
 
 type diffCmd struct {
 	bazelCacheGrpcInsecure bool
 	bazelCacheGrpcMetadata []string
+	bazelCacheHTTPURL      string
+	bazelCacheHTTPOptions  cache.HTTPClientOptions
 	bazelPath              string
 	bazelQueryExpression   string
 	bazelRcPath            string
 	bazelStderr            bool
 	buildEventsPath        string
-	credentialHelper       string
+	buildEventsBinaryPath  string
+	credentialHelpers      []string
+	jobs                   int
+	keepGoing              bool
+	base                   string
 	outPath                string
 	noPrint                bool
 	workspacePath          string
@@ -34,8 +41,17 @@ type diffCmd struct {
 	fromSnapshot *models.Snapshot
 	toSnapshot   *models.Snapshot
 
-	outputFormat OutputFormat
-	stderrPretty bool
+	metadataChanges differ.MetadataChanges
+	includeTags     []string
+	excludeTags     []string
+	labelPatterns   []string
+	filter          *differ.Filter
+
+	outputFormat    OutputFormat
+	markdownMaxRows int
+	templateArg     string
+	template        *template.Template
+	stderrPretty    bool
 
 	storageURL string
 
@@ -48,6 +64,26 @@ func newDiffCmd() *diffCmd {
 		Short: "Diff snapshots",
 		Long: `Compiles a list of the labels which have had changes between two snapshots (FROM and TO),
 together with what type of change has been made: added, removed or changed.
+Labels whose run labels or tags changed, but not their outputs, are
+metadata-changed, or as set by --metadata-changes.
+
+The changes can be filtered by the tags of their trackers with --include-tag
+and --exclude-tag, which take tags joined by "+" that all have to be present,
+like --include-tag=k8s+eu, and can be repeated to match any of them. Changes
+can also be filtered by regular expressions matching their labels, with
+--label-pattern.
+
+--format=markdown writes a summary for pull request comments, listing at
+most --markdown-max-rows trackers so it fits the size limits of comments.
+
+--format=template executes a Go text/template, given by --template as a
+file, or inline if it contains "{{", over the list of changes. See the
+README for the helper functions available.
+
+--format=run-plan writes the run labels of added or changed trackers as
+JSON, each only once, with the trackers which trigger them, and separately
+those of removed trackers.
+
 If only the FROM snapshot is given, then the TO snapshot is created from the
 current state (see collect). Snapshots can either be files, tags or snapshot
 names.`,
@@ -58,6 +94,24 @@ names.`,
 		cmd: cmd,
 	}
 
+	dc.addFlags(cmd)
+
+	// diff flags
+	cmd.PersistentFlags().BoolVar(&dc.keepGoing, "keep-going", false, "record targets which failed to build as unknown, instead of failing")
+	cmd.PersistentFlags().Var(&dc.outputFormat, "format", "output format")
+	cmd.PersistentFlags().IntVar(&dc.markdownMaxRows, "markdown-max-rows", 200, "maximum number of trackers to list with --format=markdown; 0 for no limit")
+	cmd.PersistentFlags().StringVar(&dc.templateArg, "template", "", "template file, relative to workspace-path, or inline template, for --format=template")
+	cmd.PersistentFlags().BoolVar(&dc.stderrPretty, "stderr-pretty", false, "pretty-print in stderr in addition")
+
+	cmd.RunE = dc.runDiff
+
+	return dc
+}
+
+// addFlags adds the flags for computing a diff, which are shared with the
+// run command. --keep-going is added by each command, as it also applies to
+// running with the run command.
+func (dc *diffCmd) addFlags(cmd *cobra.Command) {
 	// bazel flags
 	cmd.PersistentFlags().StringVar(&dc.bazelPath, "bazel-path", "", "path to the bazel executable")
 	cmd.PersistentFlags().StringVar(&dc.bazelRcPath, "bazelrc", "", ".bazelrc path")
@@ -66,49 +120,24 @@ names.`,
 	// collect flags
 	cmd.PersistentFlags().BoolVar(&dc.bazelCacheGrpcInsecure, "bazel_cache_grpc_insecure", false, "use insecure connection for grpc bazel cache")
 	cmd.PersistentFlags().StringArrayVar(&dc.bazelCacheGrpcMetadata, "bazel_cache_grpc_metadata", []string{}, "add metadata to connection for grpc bazel cache")
+	cmd.PersistentFlags().StringVar(&dc.bazelCacheHTTPURL, "bazel_cache_http_url", "", "base url of an http bazel cache, to retrieve outputs from by digest")
+	cmd.PersistentFlags().StringVar(&dc.bazelCacheHTTPOptions.TLSCertificate, "bazel_cache_http_tls_certificate", "", "CA certificates to verify the http bazel cache with, relative to workspace-path")
+	cmd.PersistentFlags().StringVar(&dc.bazelCacheHTTPOptions.TLSClientCertificate, "bazel_cache_http_tls_client_certificate", "", "client certificate for the http bazel cache, relative to workspace-path")
+	cmd.PersistentFlags().StringVar(&dc.bazelCacheHTTPOptions.TLSClientKey, "bazel_cache_http_tls_client_key", "", "client key for the http bazel cache, relative to workspace-path")
+	cmd.PersistentFlags().BoolVar(&dc.bazelCacheHTTPOptions.TLSInsecureSkipVerify, "bazel_cache_http_tls_insecure_skip_verify", false, "don't verify the certificate of the http bazel cache")
 	cmd.PersistentFlags().StringVar(&dc.bazelQueryExpression, "bazel-query", "//...", "the bazel query expression to consider")
 	cmd.PersistentFlags().StringVar(&dc.buildEventsPath, "build_event_json_file", "", "a bazel build event json file")
+	cmd.PersistentFlags().StringVar(&dc.buildEventsBinaryPath, "build_event_binary_file", "", "a bazel build event binary (delimited protobuf) file")
 	cmd.PersistentFlags().BoolVar(&dc.bazelStderr, "bazel_stderr", false, "show stderr from bazel")
-	cmd.PersistentFlags().StringVar(&dc.credentialHelper, "credential_helper", "", "path to a credential helper, relative to workspace-path")
-	cmd.PersistentFlags().Var(&dc.outputFormat, "format", "output format")
+	cmd.PersistentFlags().StringArrayVar(&dc.credentialHelpers, "credential_helper", nil, "credential helper as [<host-pattern>=]<path>, relative to workspace-path (see Bazel's --credential_helper); can be repeated")
+	cmd.PersistentFlags().IntVar(&dc.jobs, "jobs", defaultJobs, "number of change trackers to retrieve concurrently")
+	cmd.PersistentFlags().StringVar(&dc.base, "base", "", "snapshot or tag to carry forward the trackers of targets which failed to build from; ignored without --keep-going")
+	cmd.PersistentFlags().String("metadata-changes", "report", `how to report labels whose run labels or tags changed, but not their digest: "report" as metadata-changed, "changed" or "ignore"`)
+	cmd.PersistentFlags().StringArrayVar(&dc.includeTags, "include-tag", nil, "only report trackers with all of these tags, joined by +; can be repeated to match any")
+	cmd.PersistentFlags().StringArrayVar(&dc.excludeTags, "exclude-tag", nil, "don't report trackers with all of these tags, joined by +; can be repeated to match any")
+	cmd.PersistentFlags().StringArrayVar(&dc.labelPatterns, "label-pattern", nil, "only report labels matching this regular expression; can be repeated to match any")
 	cmd.PersistentFlags().StringVar(&dc.outPath, "out", "", "output file path")
 	cmd.PersistentFlags().BoolVar(&dc.noPrint, "no-print", false, "don't print if not writing to file")
-	cmd.PersistentFlags().BoolVar(&dc.stderrPretty, "stderr-pretty", false, "pretty-print in stderr in addition")
-
-	cmd.RunE = dc.runDiff
-
-	return dc
-}
-
-func (dc *diffCmd) resolveSnapshot(ctx context.Context, name string) (*models.Snapshot, error) {
-	// Might be a file
-	if _, err := os.Stat(name); err != nil && !os.IsNotExist(err) {
-		return nil, fmt.Errorf("failed to look for file: %w", err)
-	} else if err == nil {
-		fileBytes, err := os.ReadFile(name)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read file %s: %w", name, err)
-		}
-		snapshot := &models.Snapshot{}
-		return snapshot, json.Unmarshal(fileBytes, snapshot)
-	}
-
-	// If the name is not a file, we'll have to look it up in the store.
-	if dc.storageURL == "" {
-		return nil, fmt.Errorf("no storage provided, cannot resolve snapshot %s", name)
-	}
-
-	store, err := storage.NewStorage(dc.storageURL)
-	if err != nil {
-		return nil, fmt.Errorf("open storage: %w", err)
-	}
-
-	getArgs := getter.GetArgs{
-		Name:      name,
-		SkipNames: false,
-		SkipTags:  false,
-	}
-	return getter.NewGetter(store).Get(ctx, &getArgs)
 }
 
 func (dc *diffCmd) checkArgs() error {
@@ -126,6 +155,18 @@ func (dc *diffCmd) checkArgs() error {
 	}
 	dc.storageURL = storageURL
 
+	metadataChanges, err := dc.cmd.Flags().GetString("metadata-changes")
+	if err != nil {
+		return err
+	}
+	if dc.metadataChanges, err = differ.ParseMetadataChanges(metadataChanges); err != nil {
+		return err
+	}
+
+	if dc.filter, err = differ.NewFilter(dc.includeTags, dc.excludeTags, dc.labelPatterns); err != nil {
+		return err
+	}
+
 	if dc.workspacePath == "" {
 		if wsDir := os.Getenv("BUILD_WORKSPACE_DIRECTORY"); wsDir != "" {
 			dc.workspacePath = wsDir
@@ -134,6 +175,32 @@ func (dc *diffCmd) checkArgs() error {
 		}
 	}
 
+	if dc.markdownMaxRows < 0 {
+		return fmt.Errorf("--markdown-max-rows must not be negative: %d", dc.markdownMaxRows)
+	}
+
+	if dc.jobs < 1 {
+		return fmt.Errorf("--jobs must be at least 1: %d", dc.jobs)
+	}
+
+	if dc.buildEventsPath != "" && dc.buildEventsBinaryPath != "" {
+		return fmt.Errorf("only one of --build_event_json_file and --build_event_binary_file can be used")
+	}
+
+	if (dc.outputFormat == formatTemplate) != (dc.templateArg != "") {
+		return fmt.Errorf("--template must be given if and only if --format=template")
+	}
+	if dc.templateArg != "" {
+		if dc.template, err = dc.loadTemplate(); err != nil {
+			return err
+		}
+	}
+
+	if dc.base != "" && !dc.keepGoing {
+		log.Printf("ignoring --base, as trackers are only carried forward with --keep-going")
+		dc.base = ""
+	}
+
 	if dc.outPath != "" && !path.IsAbs(dc.outPath) {
 		dc.outPath = path.Join(dc.workspacePath, dc.outPath)
 	}
@@ -142,6 +209,16 @@ func (dc *diffCmd) checkArgs() error {
 		dc.bazelRcPath = path.Join(dc.workspacePath, dc.bazelRcPath)
 	}
 
+	for _, p := range []*string{
+		&dc.bazelCacheHTTPOptions.TLSCertificate,
+		&dc.bazelCacheHTTPOptions.TLSClientCertificate,
+		&dc.bazelCacheHTTPOptions.TLSClientKey,
+	} {
+		if *p != "" && !path.IsAbs(*p) {
+			*p = path.Join(dc.workspacePath, *p)
+		}
+	}
+
 	return nil
 }
 
@@ -152,66 +229,128 @@ func (dc *diffCmd) runDiff(cmd *cobra.Command, args []string) error {
 
 	ctx := context.Background()
 
+	changes, err := dc.diff(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	diff := differ.NewDiffer()
+
+	if dc.stderrPretty {
+		if err := diff.DiffOutputPretty(os.Stderr, changes); err != nil {
+			return err
+		}
+	}
+
+	switch dc.outputFormat {
+	case formatLabel:
+		if err := diff.DiffOutputLabel(os.Stdout, changes); err != nil {
+			return err
+		}
+	case formatJSON:
+		if err := diff.DiffOutputJSON(os.Stdout, changes); err != nil {
+			return err
+		}
+	case formatPretty:
+		if err := diff.DiffOutputPretty(os.Stdout, changes); err != nil {
+			return err
+		}
+	case formatMarkdown:
+		if err := diff.DiffOutputMarkdown(os.Stdout, changes, dc.markdownMaxRows); err != nil {
+			return err
+		}
+	case formatTemplate:
+		if err := diff.DiffOutputTemplate(os.Stdout, changes, dc.template); err != nil {
+			return err
+		}
+	case formatRunPlan:
+		if err := diff.DiffOutputRunPlan(os.Stdout, changes); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid output format %s", dc.outputFormat)
+	}
+
+	return nil
+}
+
+// diff computes the changes between the snapshots given as args, FROM and
+// optionally TO.
+func (dc *diffCmd) diff(ctx context.Context, args []string) ([]models.TrackerChange, error) {
 	fromSnapshotName := args[0]
-	if fromSnapshot, err := dc.resolveSnapshot(ctx, fromSnapshotName); err != nil {
-		return fmt.Errorf("failed to get snapshot %s: %w", fromSnapshotName, err)
+	if fromSnapshot, err := resolveSnapshot(ctx, dc.storageURL, fromSnapshotName); err != nil {
+		return nil, fmt.Errorf("failed to get snapshot %s: %w", fromSnapshotName, err)
 	} else {
 		dc.fromSnapshot = fromSnapshot
 	}
 
 	if len(args) == 2 {
 		toSnapshotName := args[1]
-		if toSnapshot, err := dc.resolveSnapshot(ctx, toSnapshotName); err != nil {
-			return fmt.Errorf("failed to get snapshot %s: %w", toSnapshotName, err)
+		if toSnapshot, err := resolveSnapshot(ctx, dc.storageURL, toSnapshotName); err != nil {
+			return nil, fmt.Errorf("failed to get snapshot %s: %w", toSnapshotName, err)
 		} else {
 			dc.toSnapshot = toSnapshot
 		}
 	}
 
-	diff := differ.NewDiffer()
+	var baseSnapshot *models.Snapshot
+	if dc.base != "" {
+		if snapshot, err := resolveSnapshot(ctx, dc.storageURL, dc.base); err != nil {
+			return nil, fmt.Errorf("failed to get base snapshot %s: %w", dc.base, err)
+		} else {
+			baseSnapshot = snapshot
+		}
+	}
+
 	diffArgs := differ.DiffArgs{
 		BazelCacheGrpcs:        !dc.bazelCacheGrpcInsecure,
 		BazelCacheGrpcMetadata: dc.bazelCacheGrpcMetadata,
+		BazelCacheHTTPURL:      dc.bazelCacheHTTPURL,
+		BazelCacheHTTPOptions:  dc.bazelCacheHTTPOptions,
 		BazelExpression:        dc.bazelQueryExpression,
 		BazelPath:              dc.bazelPath,
 		BazelRcPath:            dc.bazelRcPath,
 		BazelWorkspacePath:     dc.workspacePath,
 		BazelWriteStderr:       dc.bazelStderr,
 		BuildEventsPath:        dc.buildEventsPath,
-		CredentialHelper:       dc.credentialHelper,
+		BuildEventsBinaryPath:  dc.buildEventsBinaryPath,
+		CredentialHelpers:      dc.credentialHelpers,
+		Jobs:                   dc.jobs,
 		OutPath:                dc.outPath,
 		NoPrint:                dc.noPrint,
+		KeepGoing:              dc.keepGoing,
+		BaseSnapshot:           baseSnapshot,
 		FromSnapshot:           dc.fromSnapshot,
 		ToSnapshot:             dc.toSnapshot,
+		MetadataChanges:        dc.metadataChanges,
+		Filter:                 dc.filter,
 	}
 
-	changes, err := diff.Diff(&diffArgs)
+	changes, err := differ.NewDiffer().Diff(&diffArgs)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if dc.stderrPretty {
-		if err := diff.DiffOutputPretty(os.Stderr, changes); err != nil {
-			return err
-		}
-	}
+	log.Printf("from: %s", describeMetadata(diffArgs.FromSnapshot.Metadata))
+	log.Printf("to:   %s", describeMetadata(diffArgs.ToSnapshot.Metadata))
 
-	switch dc.outputFormat {
-	case formatLabel:
-		if err := diff.DiffOutputLabel(os.Stdout, changes); err != nil {
-			return err
-		}
-	case formatJSON:
-		if err := diff.DiffOutputJSON(os.Stdout, changes); err != nil {
-			return err
-		}
-	case formatPretty:
-		if err := diff.DiffOutputPretty(os.Stdout, changes); err != nil {
-			return err
-		}
-	default:
-		return fmt.Errorf("invalid output format %s", dc.outputFormat)
+	return changes, nil
+}
+
+// loadTemplate parses --template, which is an inline template if it
+// contains "{{", or else a file.
+func (dc *diffCmd) loadTemplate() (*template.Template, error) {
+	if strings.Contains(dc.templateArg, "{{") {
+		return differ.ParseTemplate("template", dc.templateArg)
 	}
 
-	return nil
+	templatePath := dc.templateArg
+	if !path.IsAbs(templatePath) {
+		templatePath = path.Join(dc.workspacePath, templatePath)
+	}
+	text, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template: %w", err)
+	}
+	return differ.ParseTemplate(path.Base(templatePath), string(text))
 }
@@ -9,9 +9,12 @@ import (
 type OutputFormat string
 
 const (
-	formatLabel  OutputFormat = "label"
-	formatJSON   OutputFormat = "json"
-	formatPretty OutputFormat = "pretty"
+	formatLabel    OutputFormat = "label"
+	formatJSON     OutputFormat = "json"
+	formatPretty   OutputFormat = "pretty"
+	formatMarkdown OutputFormat = "markdown"
+	formatTemplate OutputFormat = "template"
+	formatRunPlan  OutputFormat = "run-plan"
 )
 
 // String is used both by fmt.Print and by Cobra in help text
@@ -22,11 +25,11 @@ func (e *OutputFormat) String() string {
 // Set must have pointer receiver so it doesn't change the value of a copy
 func (e *OutputFormat) Set(v string) error {
 	switch v {
-	case "label", "json", "pretty":
+	case "label", "json", "pretty", "markdown", "template", "run-plan":
 		*e = OutputFormat(v)
 		return nil
 	default:
-		return errors.New(`must be one of "label", "json", or "pretty"`)
+		return errors.New(`must be one of "label", "json", "pretty", "markdown", "template", or "run-plan"`)
 	}
 }
 
@@ -0,0 +1,135 @@
+/* Copyright 2022 Cognite AS */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/pruner"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+)
+
+type gcCmd struct {
+	maxAge      string
+	keepLatest  int
+	keepHistory int
+	dryRun      bool
+
+	maxAgeDuration time.Duration
+	storageURL     string
+
+	cmd *cobra.Command
+}
+
+func newGCCmd() *gcCmd {
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete old snapshots",
+		Long: `Deletes the snapshots in the storage which are older than --max-age, or
+beyond the --keep most recent; if both are set, snapshots kept by either
+are kept. Snapshots which are tagged, or in the --keep-history latest
+entries of the history of a tag, are never deleted.
+
+The age of a snapshot is from when it was collected, in its metadata, as
+migrate and push --overwrite rewrite snapshots. Snapshots without one are
+as old as their last modification.
+
+Tags are listed again right before each snapshot is deleted, and the ones
+changed since gc started are read again, so snapshots tagged while gc runs
+are kept. A tag moved to a snapshot in the moment between that check and
+its deletion can still point at a deleted snapshot, so avoid tagging old
+snapshots while gc runs.`,
+		Args: cobra.NoArgs,
+	}
+
+	gc := &gcCmd{
+		cmd: cmd,
+	}
+
+	cmd.PersistentFlags().StringVar(&gc.maxAge, "max-age", "", "delete snapshots older than this, e.g. 720h or 30d")
+	cmd.PersistentFlags().IntVar(&gc.keepLatest, "keep", 0, "keep this many of the most recent snapshots")
+	cmd.PersistentFlags().IntVar(&gc.keepHistory, "keep-history", 10, "keep the snapshots in this many of the latest entries of each tag's history")
+	cmd.PersistentFlags().BoolVar(&gc.dryRun, "dry-run", false, "only list the snapshots which would be deleted")
+
+	cmd.RunE = gc.runGC
+
+	return gc
+}
+
+func (gc *gcCmd) checkArgs() error {
+	storageURL, err := gc.cmd.Flags().GetString("storage-url")
+	if err != nil {
+		return err
+	}
+	if storageURL == "" {
+		return fmt.Errorf("--storage-url not specified")
+	}
+	gc.storageURL = storageURL
+
+	if gc.maxAge == "" && gc.keepLatest <= 0 {
+		return fmt.Errorf("--max-age or --keep must be set")
+	}
+	if gc.maxAge != "" {
+		gc.maxAgeDuration, err = parseAge(gc.maxAge)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (gc *gcCmd) runGC(cmd *cobra.Command, args []string) error {
+	if err := gc.checkArgs(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	log.Printf("storage:    %s", gc.storageURL)
+
+	store, err := storage.NewStorage(gc.storageURL)
+	if err != nil {
+		return fmt.Errorf("open storage client: %w", err)
+	}
+
+	pruneArgs := pruner.PruneArgs{
+		MaxAge:      gc.maxAgeDuration,
+		KeepLatest:  gc.keepLatest,
+		KeepHistory: gc.keepHistory,
+		DryRun:      gc.dryRun,
+	}
+	result, err := pruner.NewPruner(store).Prune(ctx, &pruneArgs)
+	if err != nil {
+		return err
+	}
+
+	verb := "deleted"
+	if gc.dryRun {
+		verb = "would delete"
+	}
+	log.Printf("%s %d snapshots, kept %d", verb, len(result.Deleted), result.Kept)
+
+	return nil
+}
+
+// parseAge parses a duration, which may also be in days, like 30d.
+func parseAge(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("--max-age must be a positive duration (e.g. 720h or 30d): %s", s)
+	}
+	return d, nil
+}
@@ -19,6 +19,7 @@ import (
 type getCmd struct {
 	skipTags  bool
 	skipNames bool
+	metadata  bool
 	name      string
 
 	storageURL string
@@ -41,6 +42,7 @@ priority.`,
 
 	cmd.PersistentFlags().BoolVar(&gc.skipTags, "skip-tags", false, "don't look up by tag")
 	cmd.PersistentFlags().BoolVar(&gc.skipNames, "skip-names", false, "don't look up by name")
+	cmd.PersistentFlags().BoolVar(&gc.metadata, "metadata", false, "only print how the snapshot was made (see collect)")
 
 	cmd.RunE = gc.runGet
 
@@ -82,7 +84,15 @@ func (gc *getCmd) runGet(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	snapshotBytes, err := json.MarshalIndent(snapshot, "", "  ")
+	var out any = snapshot
+	if gc.metadata {
+		if snapshot.Metadata == nil {
+			return fmt.Errorf("snapshot %s has no metadata, it was made by an older version", gc.name)
+		}
+		out = snapshot.Metadata
+	}
+
+	snapshotBytes, err := json.MarshalIndent(out, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal snapshot: %w", err)
 	}
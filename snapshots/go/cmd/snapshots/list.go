@@ -0,0 +1,125 @@
+/* Copyright 2022 Cognite AS */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/lister"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+)
+
+type listCmd struct {
+	prefix string
+	since  string
+	sortBy string
+
+	sinceTime    time.Time
+	outputFormat OutputFormat
+
+	storageURL string
+
+	cmd *cobra.Command
+}
+
+func newListCmd() *listCmd {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List snapshots and tags",
+		Long: `Lists the snapshots in the storage, with their size, when they were last
+modified and the tags pointing at them, and the tags with the snapshots they
+point at.`,
+		Args: cobra.NoArgs,
+	}
+
+	lc := &listCmd{
+		outputFormat: formatPretty,
+		cmd:          cmd,
+	}
+
+	cmd.PersistentFlags().StringVar(&lc.prefix, "prefix", "", "only list snapshots and tags with names starting with the prefix")
+	cmd.PersistentFlags().StringVar(&lc.since, "since", "", "only list snapshots and tags modified since a time (e.g. 2006-01-02 or 2006-01-02T15:04:05Z) or a duration ago (e.g. 72h)")
+	cmd.PersistentFlags().StringVar(&lc.sortBy, "sort", "name", `sort by "name" or "time" (newest first)`)
+	cmd.PersistentFlags().Var(&lc.outputFormat, "format", `output format, "json" or "pretty"`)
+
+	cmd.RunE = lc.runList
+
+	return lc
+}
+
+func (lc *listCmd) checkArgs() error {
+	storageURL, err := lc.cmd.Flags().GetString("storage-url")
+	if err != nil {
+		return err
+	}
+	if storageURL == "" {
+		return fmt.Errorf("--storage-url not specified")
+	}
+	lc.storageURL = storageURL
+
+	if lc.since != "" {
+		since, err := parseSince(lc.since, time.Now())
+		if err != nil {
+			return err
+		}
+		lc.sinceTime = since
+	}
+
+	if lc.sortBy != "name" && lc.sortBy != "time" {
+		return fmt.Errorf(`--sort must be "name" or "time": %s`, lc.sortBy)
+	}
+
+	if lc.outputFormat != formatJSON && lc.outputFormat != formatPretty {
+		return fmt.Errorf(`--format must be "json" or "pretty": %s`, lc.outputFormat)
+	}
+
+	return nil
+}
+
+func (lc *listCmd) runList(cmd *cobra.Command, args []string) error {
+	if err := lc.checkArgs(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	store, err := storage.NewStorage(lc.storageURL)
+	if err != nil {
+		return fmt.Errorf("open storage client: %w", err)
+	}
+
+	listArgs := lister.ListArgs{
+		Prefix:     lc.prefix,
+		Since:      lc.sinceTime,
+		SortByTime: lc.sortBy == "time",
+	}
+	list := lister.NewLister(store)
+	result, err := list.List(ctx, &listArgs)
+	if err != nil {
+		return err
+	}
+
+	if lc.outputFormat == formatJSON {
+		return list.ListOutputJSON(os.Stdout, result)
+	}
+	return list.ListOutputPretty(os.Stdout, result)
+}
+
+// parseSince parses a time, as a date, a RFC 3339 time, or a duration
+// before now.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("--since must be a time (e.g. 2006-01-02 or 2006-01-02T15:04:05Z) or a duration (e.g. 72h): %s", s)
+}
@@ -1,16 +1,27 @@
 package main
 
 import (
+	"errors"
 	"log"
 	"os"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/tagger"
 )
 
+// exitTagMoved is the exit code when a tag wasn't moved, because it didn't
+// point at the expected snapshot (see tag --expect).
+const exitTagMoved = 3
+
 func main() {
 	log.SetPrefix("snapshots: ")
 	log.SetFlags(0) // don't print timestamps
 
 	err := Execute(os.Args[1:])
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		if errors.Is(err, tagger.ErrTagMoved) {
+			os.Exit(exitTagMoved)
+		}
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,93 @@
+/* Copyright 2022 Cognite AS */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/migrater"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+)
+
+type migrateCmd struct {
+	dryRun bool
+
+	storageURL string
+
+	cmd *cobra.Command
+}
+
+func newMigrateCmd() *migrateCmd {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate stored snapshots",
+		Long: `Rewrites all snapshots in the storage which were written by older versions
+of snapshots to the current version of the snapshot format. Snapshots of
+older versions can still be read without migrating them.
+
+As rewriting a snapshot resets its modification time, the time it was
+written before is recorded as its collection time in its metadata, if it
+has none. gc uses that time for the age of the snapshot.`,
+		Args: cobra.NoArgs,
+	}
+
+	mc := &migrateCmd{
+		cmd: cmd,
+	}
+
+	cmd.PersistentFlags().BoolVar(&mc.dryRun, "dry-run", false, "only list the snapshots which would be migrated")
+
+	cmd.RunE = mc.runMigrate
+
+	return mc
+}
+
+func (mc *migrateCmd) checkArgs() error {
+	storageURL, err := mc.cmd.Flags().GetString("storage-url")
+	if err != nil {
+		return err
+	}
+	if storageURL == "" {
+		return fmt.Errorf("--storage-url not specified")
+	}
+	mc.storageURL = storageURL
+
+	return nil
+}
+
+func (mc *migrateCmd) runMigrate(cmd *cobra.Command, args []string) error {
+	if err := mc.checkArgs(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	log.Printf("storage:    %s", mc.storageURL)
+	log.Printf("version:    %d", models.SnapshotVersion)
+
+	store, err := storage.NewStorage(mc.storageURL)
+	if err != nil {
+		return fmt.Errorf("open storage client: %w", err)
+	}
+
+	migrateArgs := migrater.MigrateArgs{
+		DryRun: mc.dryRun,
+	}
+	result, err := migrater.NewMigrater(store).Migrate(ctx, &migrateArgs)
+	if err != nil {
+		return err
+	}
+
+	verb := "migrated"
+	if mc.dryRun {
+		verb = "would migrate"
+	}
+	log.Printf("%s %d snapshots, %d already of the current version", verb, len(result.Migrated), result.Current)
+
+	return nil
+}
@@ -4,7 +4,6 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -14,6 +13,7 @@ import (
 
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/pusher"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/snapshotfile"
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
 )
 
@@ -21,6 +21,10 @@ type pushCmd struct {
 	name          string
 	snapshotPath  string
 	workspacePath string
+	overwrite     bool
+	compression   string
+
+	codec snapshotfile.Codec
 
 	snapshot *models.Snapshot
 
@@ -34,7 +38,14 @@ func newPushCmd() *pushCmd {
 		Use:   "push",
 		Short: "Push snapshot",
 		Long: `Pushes a snapshot specified by path. Name defaults to the current git HEAD,
-or can optionally be specified.`,
+or can optionally be specified.
+
+Existing snapshots are not replaced, as they may be tagged. Pushing a
+snapshot which already exists succeeds if the existing snapshot has the same
+labels and digests, and fails otherwise, unless --overwrite is given.
+
+With --compression, the snapshot is stored compressed, which is worthwhile
+for large repositories. Compressed snapshots are read like any other.`,
 	}
 
 	pc := &pushCmd{
@@ -44,6 +55,8 @@ or can optionally be specified.`,
 	cmd.PersistentFlags().StringVar(&pc.name, "name", "", "snapshot name (defaults to HEAD git sha)")
 	cmd.PersistentFlags().StringVar(&pc.snapshotPath, "snapshot-path", "", "path to snapshot to be pushed")
 	cmd.PersistentFlags().StringVar(&pc.workspacePath, "workspace-path", "", "workspace path")
+	cmd.PersistentFlags().BoolVar(&pc.overwrite, "overwrite", false, "replace an existing snapshot of the same name, if different")
+	cmd.PersistentFlags().StringVar(&pc.compression, "compression", "none", `compression of the stored snapshot, "none", "gzip" or "zstd"`)
 
 	cmd.RunE = pc.runPush
 
@@ -66,6 +79,10 @@ func (pc *pushCmd) checkArgs() error {
 	}
 	pc.storageURL = storageURL
 
+	if pc.codec, err = snapshotfile.ParseCodec(pc.compression); err != nil {
+		return err
+	}
+
 	// Read the manifest
 	if pc.snapshot == nil && pc.snapshotPath != "" {
 		// If it's a relative path, assume workspace-relative. The command is
@@ -75,12 +92,11 @@ func (pc *pushCmd) checkArgs() error {
 		}
 
 		log.Println("reading snapshot from", pc.snapshotPath)
-		pc.snapshot = &models.Snapshot{}
 		contents, err := os.ReadFile(pc.snapshotPath)
 		if err != nil {
 			return fmt.Errorf("failed to read snapshot path: %w", err)
 		}
-		if err := json.Unmarshal(contents, &pc.snapshot); err != nil {
+		if pc.snapshot, err = snapshotfile.Decode(contents); err != nil {
 			return fmt.Errorf("failed to read snapshot %s: %w", pc.snapshotPath, err)
 		}
 	}
@@ -106,16 +122,25 @@ func (pc *pushCmd) runPush(cmd *cobra.Command, args []string) error {
 	}
 
 	pushArgs := pusher.PushArgs{
-		Name:     pc.name,
-		Snapshot: pc.snapshot,
+		Name:      pc.name,
+		Snapshot:  pc.snapshot,
+		Overwrite: pc.overwrite,
+		Codec:     pc.codec,
 	}
-	obj, err := pusher.NewPusher(store).Push(ctx, &pushArgs)
+	result, err := pusher.NewPusher(store).Push(ctx, &pushArgs)
 	if err != nil {
 		return err
 	}
 
-	contentLength := obj.ContentLength
-	log.Printf("pushed snapshot of %d bytes: %s", contentLength, obj.Path)
+	obj := result.Object
+	switch {
+	case result.Identical:
+		log.Printf("identical snapshot already pushed: %s", obj.Path)
+	case result.Overwritten:
+		log.Printf("overwrote existing snapshot with %d bytes: %s", obj.ContentLength, obj.Path)
+	default:
+		log.Printf("pushed snapshot of %d bytes: %s", obj.ContentLength, obj.Path)
+	}
 
 	return nil
 }
@@ -24,11 +24,16 @@ func newRootCmd() *rootCmd {
 		},
 	}
 
+	cmd.AddCommand(newBESServerCmd().cmd)
 	cmd.AddCommand(newCollectCmd().cmd)
 	cmd.AddCommand(newDiffCmd().cmd)
 	cmd.AddCommand(newDigestCmd().cmd)
+	cmd.AddCommand(newGCCmd().cmd)
 	cmd.AddCommand(newGetCmd().cmd)
+	cmd.AddCommand(newListCmd().cmd)
+	cmd.AddCommand(newMigrateCmd().cmd)
 	cmd.AddCommand(newPushCmd().cmd)
+	cmd.AddCommand(newRunCmd().cmd)
 	cmd.AddCommand(newTagCmd().cmd)
 
 	rc := &rootCmd{
@@ -0,0 +1,154 @@
+/* Copyright 2022 Cognite AS */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/bazel"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/differ"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/runner"
+)
+
+type runCmd struct {
+	diff *diffCmd
+
+	parallelism int
+	timeout     time.Duration
+	dryRun      bool
+	reportPath  string
+
+	cmd *cobra.Command
+}
+
+func newRunCmd() *runCmd {
+	cmd := &cobra.Command{
+		Use:   "run FROM [TO]",
+		Short: "Run the run labels of changed trackers",
+		Long: `Diffs two snapshots like diff, and runs the run labels of the added or
+changed trackers, each only once (see diff --format=run-plan). The run
+labels of removed trackers are not run.
+
+The run labels are built one at a time, as Bazel runs one command at a
+time, and then run --parallelism at a time. Their output is prefixed with
+their label. If one fails, the rest are skipped, unless --keep-going is
+set. --keep-going also records the targets which fail to build as unknown,
+like diff --keep-going, so the run labels of the others are still run;
+with --base, their trackers are carried forward instead.
+
+With --report, the outcome of each run label is written as JSON.`,
+		Args: cobra.RangeArgs(1, 2),
+	}
+
+	rc := &runCmd{
+		diff: &diffCmd{cmd: cmd},
+		cmd:  cmd,
+	}
+
+	rc.diff.addFlags(cmd)
+
+	// run flags
+	cmd.PersistentFlags().IntVar(&rc.parallelism, "parallelism", 4, "number of run labels to run at once")
+	cmd.PersistentFlags().DurationVar(&rc.timeout, "timeout", 0, "time each run label may run for, e.g. 10m; 0 for no limit")
+	cmd.PersistentFlags().BoolVar(&rc.diff.keepGoing, "keep-going", false, "record targets which failed to build as unknown, and run the remaining run labels after one failed")
+	cmd.PersistentFlags().BoolVar(&rc.dryRun, "dry-run", false, "only list the run labels which would be run")
+	cmd.PersistentFlags().StringVar(&rc.reportPath, "report", "", "path to write the outcome of each run label to as JSON, relative to workspace-path")
+
+	cmd.RunE = rc.runRun
+
+	return rc
+}
+
+func (rc *runCmd) checkArgs() error {
+	if err := rc.diff.checkArgs(); err != nil {
+		return err
+	}
+
+	if rc.parallelism < 1 {
+		return fmt.Errorf("--parallelism must be at least 1: %d", rc.parallelism)
+	}
+	if rc.timeout < 0 {
+		return fmt.Errorf("--timeout must not be negative: %s", rc.timeout)
+	}
+
+	if rc.reportPath != "" && !path.IsAbs(rc.reportPath) {
+		rc.reportPath = path.Join(rc.diff.workspacePath, rc.reportPath)
+	}
+
+	return nil
+}
+
+func (rc *runCmd) runRun(cmd *cobra.Command, args []string) error {
+	if err := rc.checkArgs(); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	changes, err := rc.diff.diff(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	plan := differ.NewRunPlan(changes)
+	log.Printf("%d run labels to run", len(plan.Run))
+	if len(plan.Removed) > 0 {
+		log.Printf("not running %d run labels of removed trackers", len(plan.Removed))
+	}
+
+	bstderr := io.Discard
+	if rc.diff.bazelStderr {
+		bstderr = os.Stderr
+	}
+	bazelc := bazel.NewClient(rc.diff.bazelPath, rc.diff.workspacePath, bstderr)
+
+	runArgs := runner.RunArgs{
+		Targets:     plan.Run,
+		BazelRcPath: rc.diff.bazelRcPath,
+		Parallelism: rc.parallelism,
+		Timeout:     rc.timeout,
+		KeepGoing:   rc.diff.keepGoing,
+		DryRun:      rc.dryRun,
+	}
+	report, runErr := runner.NewRunner(bazelc, os.Stderr).Run(ctx, &runArgs)
+	if report == nil {
+		return runErr
+	}
+
+	if rc.dryRun {
+		for _, result := range report.Results {
+			fmt.Println(result.Label)
+		}
+	}
+
+	if rc.reportPath != "" {
+		if err := writeReport(rc.reportPath, report); err != nil {
+			return errors.Join(runErr, err)
+		}
+	}
+
+	return runErr
+}
+
+// writeReport writes a run report as JSON.
+func writeReport(reportPath string, report *models.RunReport) error {
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	if err := os.WriteFile(reportPath, out, 0o644); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 
 	"github.com/spf13/cobra"
 
@@ -17,6 +18,10 @@ type tagCmd struct {
 	workspacePath string
 	snapshotName  string
 	tagName       string
+	message       string
+	expect        *string
+	delete        bool
+	force         bool
 
 	storageURL string
 
@@ -31,6 +36,24 @@ func newTagCmd() *tagCmd {
 defaults to the current git HEAD. Tagging a snapshot creates a named
 reference to it. For example, a tag "deployed" can be a reference to the
 snapshot which was most recently deployed.
+
+Every time a tag is moved, this is recorded in the tag's history, with who
+moved it: $SNAPSHOTS_ACTOR, or else the git user. See 'snapshots tag log'.
+Earlier targets of a tag can be referred to as <tag>@{n}, like deployed@{1}
+for the snapshot which was deployed before the current one.
+
+With --expect, the tag is only moved if it still points at the expected
+snapshot (or, with --expect="", if it doesn't exist yet), so concurrent
+pipelines can't overwrite each other's tags. If the tag was moved, tag
+exits with code 3.
+
+Tags can be protected from being moved or deleted by accident, by
+tag-policy.json in the storage, like:
+
+  {"protected": [{"pattern": "production", "actors": ["ci@example.com"]}]}
+
+Tags matching a pattern can then only be changed by the actors listed, or
+with --force.
 `,
 		Args: cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
@@ -45,16 +68,26 @@ snapshot which was most recently deployed.
 	cmd.PersistentFlags().StringVar(&cc.workspacePath, "workspace-path", "", "workspace path")
 
 	// tag flags
-	cmd.PersistentFlags().StringVar(&cc.snapshotName, "name", "", "snapshot name")
+	cmd.Flags().StringVar(&cc.snapshotName, "name", "", "snapshot name")
+	cmd.Flags().StringVarP(&cc.message, "message", "m", "", "message to record in the tag's history")
+	cmd.Flags().String("expect", "", "only move the tag if it points at this snapshot (\"\" if it must not exist)")
+	cmd.Flags().BoolVar(&cc.delete, "delete", false, "delete the tag instead")
+	cmd.Flags().BoolVar(&cc.force, "force", false, "move or delete the tag even if it's protected")
 
 	cmd.RunE = cc.runTag
 
+	cmd.AddCommand(newTagLogCmd().cmd)
+
 	return cc
 }
 
 func (tc *tagCmd) checkArgs(args []string) error {
+	if tc.delete && (tc.snapshotName != "" || tc.cmd.Flags().Changed("expect")) {
+		return fmt.Errorf("--delete can't be used with --name or --expect")
+	}
+
 	// If name is not set, find name from git head
-	if tc.snapshotName == "" {
+	if tc.snapshotName == "" && !tc.delete {
 		head, err := getGitHead(tc.workspacePath)
 		if err != nil {
 			return fmt.Errorf("failed to find name from git: %w", err)
@@ -68,6 +101,14 @@ func (tc *tagCmd) checkArgs(args []string) error {
 	}
 	tc.storageURL = storageURL
 
+	if tc.cmd.Flags().Changed("expect") {
+		expect, err := tc.cmd.Flags().GetString("expect")
+		if err != nil {
+			return err
+		}
+		tc.expect = &expect
+	}
+
 	tc.tagName = args[0]
 
 	return nil
@@ -81,19 +122,27 @@ func (tc *tagCmd) runTag(cmd *cobra.Command, args []string) error {
 
 	ctx := context.Background()
 
-	log.Printf("workspace: %s", tc.workspacePath)
-	log.Printf("storage:    %s", tc.storageURL)
-	log.Printf("snapshot:  %s", tc.snapshotName)
-	log.Printf("tag:       %s", tc.tagName)
-
 	store, err := storage.NewStorage(tc.storageURL)
 	if err != nil {
 		return fmt.Errorf("open storage client: %w", err)
 	}
 
+	if tc.delete {
+		return tc.runDelete(ctx, store)
+	}
+
+	log.Printf("workspace: %s", tc.workspacePath)
+	log.Printf("storage:    %s", tc.storageURL)
+	log.Printf("snapshot:  %s", tc.snapshotName)
+	log.Printf("tag:       %s", tc.tagName)
+
 	tagArgs := tagger.TagArgs{
 		SnapshotName: tc.snapshotName,
 		TagName:      tc.tagName,
+		Actor:        getActor(tc.workspacePath),
+		Message:      tc.message,
+		Force:        tc.force,
+		Expect:       tc.expect,
 	}
 	obj, err := tagger.NewTagger(store).Tag(ctx, &tagArgs)
 	if err != nil {
@@ -104,3 +153,97 @@ func (tc *tagCmd) runTag(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+func (tc *tagCmd) runDelete(ctx context.Context, store *storage.Storage) error {
+	log.Printf("storage:    %s", tc.storageURL)
+	log.Printf("tag:       %s", tc.tagName)
+
+	deleteArgs := tagger.DeleteArgs{
+		TagName: tc.tagName,
+		Actor:   getActor(tc.workspacePath),
+		Message: tc.message,
+		Force:   tc.force,
+	}
+	previous, err := tagger.NewTagger(store).Delete(ctx, &deleteArgs)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("deleted tag %s (was %s)", tc.tagName, previous)
+
+	return nil
+}
+
+type tagLogCmd struct {
+	tagName      string
+	outputFormat OutputFormat
+
+	storageURL string
+
+	cmd *cobra.Command
+}
+
+func newTagLogCmd() *tagLogCmd {
+	cmd := &cobra.Command{
+		Use:   "log <tag>",
+		Short: "Show the history of a tag",
+		Long: `Shows where a tag has pointed, newest first, with when it was moved and
+by whom. The Ref column is the reference to use for each earlier target,
+like deployed@{1}.
+`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	lc := &tagLogCmd{
+		cmd:          cmd,
+		outputFormat: formatPretty,
+	}
+
+	cmd.Flags().Var(&lc.outputFormat, "format", `output format, "json" or "pretty"`)
+
+	cmd.RunE = lc.runTagLog
+
+	return lc
+}
+
+func (lc *tagLogCmd) checkArgs(args []string) error {
+	storageURL, err := lc.cmd.Flags().GetString("storage-url")
+	if err != nil {
+		return err
+	}
+	if storageURL == "" {
+		return fmt.Errorf("--storage-url not specified")
+	}
+	lc.storageURL = storageURL
+
+	if lc.outputFormat != formatJSON && lc.outputFormat != formatPretty {
+		return fmt.Errorf(`--format must be "json" or "pretty": %s`, lc.outputFormat)
+	}
+
+	lc.tagName = args[0]
+
+	return nil
+}
+
+func (lc *tagLogCmd) runTagLog(cmd *cobra.Command, args []string) error {
+	if err := lc.checkArgs(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	store, err := storage.NewStorage(lc.storageURL)
+	if err != nil {
+		return fmt.Errorf("open storage client: %w", err)
+	}
+
+	history, err := tagger.History(ctx, store, lc.tagName)
+	if err != nil {
+		return err
+	}
+
+	if lc.outputFormat == formatJSON {
+		return tagger.HistoryOutputJSON(os.Stdout, history)
+	}
+	return tagger.HistoryOutputPretty(os.Stdout, history)
+}
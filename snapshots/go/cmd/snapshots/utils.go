@@ -3,11 +3,26 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"os/exec"
+	"os/user"
 	"strings"
+	"time"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/getter"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/snapshotfile"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
 )
 
+// defaultJobs is the default number of change trackers
+// retrieved concurrently by collect and diff.
+// Retrieval is mostly waiting on the network,
+// so this is not tied to the number of CPUs.
+const defaultJobs = 32
+
 func getGitHead(path string) (string, error) {
 	cmd := exec.Command("git", "rev-parse", "HEAD")
 	cmd.Dir = path
@@ -19,3 +34,108 @@ func getGitHead(path string) (string, error) {
 
 	return strings.TrimSpace(string(out)), nil
 }
+
+// getActor identifies who is running snapshots, for the tag history.
+// This is $SNAPSHOTS_ACTOR if set, or else the git user configured in the
+// workspace, or else the current user.
+func getActor(workspacePath string) string {
+	if actor := os.Getenv("SNAPSHOTS_ACTOR"); actor != "" {
+		return actor
+	}
+
+	gitConfig := func(key string) string {
+		cmd := exec.Command("git", "config", "--get", key)
+		cmd.Dir = workspacePath
+		out, err := cmd.Output()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(out))
+	}
+	name, email := gitConfig("user.name"), gitConfig("user.email")
+	switch {
+	case name != "" && email != "":
+		return fmt.Sprintf("%s <%s>", name, email)
+	case email != "":
+		return email
+	case name != "":
+		return name
+	}
+
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// resolveSnapshot reads the snapshot name, which is either a file, or a tag
+// or snapshot name in the store at storageURL.
+func resolveSnapshot(ctx context.Context, storageURL, name string) (*models.Snapshot, error) {
+	// Might be a file
+	if _, err := os.Stat(name); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to look for file: %w", err)
+	} else if err == nil {
+		fileBytes, err := os.ReadFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s: %w", name, err)
+		}
+		return snapshotfile.Decode(fileBytes)
+	}
+
+	// If the name is not a file, we'll have to look it up in the store.
+	if storageURL == "" {
+		return nil, fmt.Errorf("no storage provided, cannot resolve snapshot %s", name)
+	}
+
+	store, err := storage.NewStorage(storageURL)
+	if err != nil {
+		return nil, fmt.Errorf("open storage: %w", err)
+	}
+
+	getArgs := getter.GetArgs{
+		Name:      name,
+		SkipNames: false,
+		SkipTags:  false,
+	}
+	return getter.NewGetter(store).Get(ctx, &getArgs)
+}
+
+// describeMetadata summarizes how a snapshot was made, on one line.
+func describeMetadata(metadata *models.Metadata) string {
+	if metadata == nil {
+		return "no metadata"
+	}
+
+	var parts []string
+	if git := metadata.Git; git != nil {
+		commit := "commit " + git.Commit
+		var details []string
+		if git.Branch != "" {
+			details = append(details, git.Branch)
+		}
+		if git.Dirty {
+			details = append(details, "dirty")
+		}
+		if len(details) > 0 {
+			commit += " (" + strings.Join(details, ", ") + ")"
+		}
+		parts = append(parts, commit)
+	}
+	if metadata.BazelVersion != "" {
+		parts = append(parts, "bazel "+metadata.BazelVersion)
+	}
+	if metadata.Query != "" {
+		parts = append(parts, "query "+metadata.Query)
+	}
+
+	collected := "collected " + metadata.CollectedAt.Format(time.RFC3339)
+	if metadata.Host != "" {
+		collected += " on " + metadata.Host
+	}
+	if metadata.Duration != "" {
+		collected += " in " + metadata.Duration
+	}
+	parts = append(parts, collected)
+
+	return strings.Join(parts, ", ")
+}
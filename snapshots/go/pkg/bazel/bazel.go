@@ -5,8 +5,10 @@
 package bazel
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,6 +18,14 @@ import (
 	"os/exec"
 )
 
+// ErrBuildFailed is reported after the build events of a build which failed,
+// e.g. because some targets failed to build.
+var ErrBuildFailed = errors.New("bazel build failed")
+
+// buildFailedExitCode is Bazel's exit code when the build failed, as opposed
+// to e.g. invalid arguments or crashes, which don't produce any build events.
+const buildFailedExitCode = 1
+
 // Client exposes the Bazel CLI.
 type Client struct {
 	path   string
@@ -46,6 +56,23 @@ func (c *Client) Command(ctx context.Context, args ...string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// RunScript builds a target, and writes a script to scriptPath which runs
+// it like 'bazel run' would, without holding Bazel's lock while it runs.
+func (c *Client) RunScript(ctx context.Context, bazelrc, scriptPath, label string) error {
+	args := []string{"run", fmt.Sprintf("--script_path=%s", scriptPath), label}
+	if bazelrc != "" {
+		args = append([]string{fmt.Sprintf("--bazelrc=%s", bazelrc)}, args...)
+	}
+
+	if _, err := c.Command(ctx, args...); err != nil {
+		return fmt.Errorf("failed to build %s: %w", label, err)
+	}
+	return nil
+}
+
+// BuildEventOutput runs 'bazel build' with the given arguments, and returns an
+// iterator over the build events. If the build fails, the build events are
+// still produced, followed by ErrBuildFailed.
 func (c *Client) BuildEventOutput(ctx context.Context, bazelrc string, args ...string) iter.Seq2[BuildEventOutput, error] {
 	return func(yield func(BuildEventOutput, error) bool) {
 		f, err := os.CreateTemp("", "snapshots-collect")
@@ -64,24 +91,67 @@ func (c *Client) BuildEventOutput(ctx context.Context, bazelrc string, args ...s
 			args = append([]string{fmt.Sprintf("--bazelrc=%s", bazelrc)}, args...)
 		}
 
+		var buildErr error
 		if _, err := c.Command(ctx, args...); err != nil {
-			yield(BuildEventOutput{}, fmt.Errorf("failed to build: %w", err))
-			return
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != buildFailedExitCode {
+				yield(BuildEventOutput{}, fmt.Errorf("failed to build: %w", err))
+				return
+			}
+			buildErr = ErrBuildFailed
 		}
 
 		for ev, err := range ParseBuildEventsFile(f) {
-			if !yield(ev, err) {
+			if !yield(ev, err) || err != nil {
 				return
 			}
 		}
+
+		if buildErr != nil {
+			yield(BuildEventOutput{}, buildErr)
+		}
 	}
 }
 
 // ParseBuildEventsFile returns an iterator over the build events
-// in the given reader.
+// in the given reader, in either the JSON format (see Bazel's
+// --build_event_json_file) or the binary format (see Bazel's
+// --build_event_binary_file).
+//
+// The format is detected from the first byte: JSON starts with '{' or
+// whitespace, while the binary format starts with the length of the first
+// event, which is in practice never one of these.
 //
 // The iterator is not re-usable.
 func ParseBuildEventsFile(r io.Reader) iter.Seq2[BuildEventOutput, error] {
+	return func(yield func(BuildEventOutput, error) bool) {
+		br := bufio.NewReader(r)
+		first, err := br.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return // no events
+		}
+		if err != nil {
+			yield(BuildEventOutput{}, fmt.Errorf("error parsing build event file: %w", err))
+			return
+		}
+
+		events := ParseBuildEventsBinaryFile(br)
+		switch first[0] {
+		case '{', ' ', '\t', '\r', '\n':
+			events = parseBuildEventsJSONFile(br)
+		}
+
+		for ev, err := range events {
+			if !yield(ev, err) {
+				return
+			}
+		}
+	}
+}
+
+// parseBuildEventsJSONFile returns an iterator over the build events
+// in the given reader, in the JSON format.
+func parseBuildEventsJSONFile(r io.Reader) iter.Seq2[BuildEventOutput, error] {
 	return func(yield func(BuildEventOutput, error) bool) {
 		dec := json.NewDecoder(r)
 		for {
@@ -102,3 +172,63 @@ func ParseBuildEventsFile(r io.Reader) iter.Seq2[BuildEventOutput, error] {
 		}
 	}
 }
+
+// ParseBuildEventsBinaryFile returns an iterator over the build events
+// in the given reader, in the binary format: build_event_stream.BuildEvent
+// messages, each prefixed by its length as a varint (see Bazel's
+// --build_event_binary_file).
+//
+// The iterator is not re-usable.
+func ParseBuildEventsBinaryFile(r io.Reader) iter.Seq2[BuildEventOutput, error] {
+	return func(yield func(BuildEventOutput, error) bool) {
+		br, ok := r.(byteReader)
+		if !ok {
+			br = bufio.NewReader(r)
+		}
+
+		var buf bytes.Buffer
+		for {
+			size, err := binary.ReadUvarint(br)
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err == nil && size > maxBuildEventSize {
+				err = fmt.Errorf("build event of %d bytes is too large", size)
+			}
+			if err != nil {
+				yield(BuildEventOutput{}, fmt.Errorf("error parsing build event file: %w", err))
+				return
+			}
+
+			// copying, rather than reading into a buffer of the given size,
+			// avoids large allocations for corrupt sizes
+			buf.Reset()
+			if _, err := io.CopyN(&buf, br, int64(size)); err != nil {
+				if errors.Is(err, io.EOF) {
+					err = io.ErrUnexpectedEOF
+				}
+				yield(BuildEventOutput{}, fmt.Errorf("error parsing build event file: %w", err))
+				return
+			}
+
+			beo, err := UnmarshalBuildEvent(buf.Bytes())
+			if err != nil {
+				yield(BuildEventOutput{}, fmt.Errorf("error parsing build event file: %w", err))
+				return
+			}
+
+			if !yield(beo, nil) {
+				return
+			}
+		}
+	}
+}
+
+// maxBuildEventSize is the largest build event accepted in the binary
+// format, which is the protobuf limit for a message.
+const maxBuildEventSize = 2 << 30
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
@@ -1,13 +1,19 @@
 package bazel
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
 func TestParseBuildEventsFile(t *testing.T) {
@@ -28,6 +34,34 @@ func TestParseBuildEventsFile(t *testing.T) {
 	assert.Equal(t, "//foo:qux", got[2].ID.TargetCompleted.Label)
 }
 
+func TestParseBuildEventsFile_failures(t *testing.T) {
+	input := `
+{"id":{"targetCompleted":{"label":"//foo:bar"}},"completed":{}}
+{"id":{"configuredLabel":{"label":"//foo:baz"}},"aborted":{"reason":"ANALYSIS_FAILURE","description":"oops"}}
+{"id":{"targetCompleted":{"label":"//foo:qux"}},"aborted":{}}
+{"id":{"buildFinished":{}},"finished":{"exitCode":{"name":"BUILD_FAILURE","code":1}}}
+`
+	var got []BuildEventOutput
+	for ev, err := range ParseBuildEventsFile(strings.NewReader(input)) {
+		require.NoError(t, err)
+		got = append(got, ev)
+	}
+
+	require.Len(t, got, 4)
+	assert.Equal(t, "//foo:bar", got[0].ID.Label())
+	assert.False(t, got[0].Completed.Success)
+	assert.Nil(t, got[0].Aborted)
+
+	assert.Equal(t, "//foo:baz", got[1].ID.Label())
+	assert.Equal(t, &Aborted{Reason: "ANALYSIS_FAILURE", Description: "oops"}, got[1].Aborted)
+
+	assert.Equal(t, "//foo:qux", got[2].ID.Label())
+	assert.Equal(t, &Aborted{}, got[2].Aborted)
+
+	require.NotNil(t, got[3].Finished)
+	assert.Equal(t, 1, got[3].Finished.ExitCode.Code)
+}
+
 func TestParseBuildEventsFile_errors(t *testing.T) {
 	t.Run("invalid JSON", func(t *testing.T) {
 		input := `{"id": {"targetCompleted":`
@@ -47,3 +81,193 @@ func TestParseBuildEventsFile_errors(t *testing.T) {
 		assert.ErrorIs(t, err, giveErr)
 	})
 }
+
+// binaryBuildEvents encodes target completed events for the given labels
+// in the binary format.
+func binaryBuildEvents(labels ...string) []byte {
+	var b []byte
+	for _, label := range labels {
+		id := appendBytesField(nil, buildEventIDTargetCompletedField,
+			appendStringField(nil, labelIDLabelField, label))
+		ev := appendBytesField(nil, buildEventIDField, id)
+
+		b = protowire.AppendBytes(b, ev)
+	}
+	return b
+}
+
+func TestParseBuildEventsBinaryFile(t *testing.T) {
+	input := binaryBuildEvents("//foo:bar", "//foo:baz", "//foo:"+strings.Repeat("x", 200))
+
+	var got []string
+	for ev, err := range ParseBuildEventsBinaryFile(bytes.NewReader(input)) {
+		require.NoError(t, err)
+		got = append(got, ev.ID.TargetCompleted.Label)
+	}
+
+	assert.Equal(t, []string{"//foo:bar", "//foo:baz", "//foo:" + strings.Repeat("x", 200)}, got)
+}
+
+func TestParseBuildEventsBinaryFile_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		give    []byte
+		wantErr error
+	}{
+		{
+			name:    "Truncated",
+			give:    binaryBuildEvents("//foo:bar")[:10],
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "TruncatedSize",
+			give:    []byte{0x80},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name: "TooLarge",
+			give: protowire.AppendVarint(nil, maxBuildEventSize+1),
+		},
+		{
+			name: "InvalidEvent",
+			give: protowire.AppendBytes(nil, []byte{0x0a, 0x10}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			for _, err = range ParseBuildEventsBinaryFile(bytes.NewReader(tt.give)) {
+			}
+			require.ErrorContains(t, err, "error parsing build event file")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseBuildEventsFile_detectFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		give []byte
+	}{
+		{name: "JSON", give: []byte(`{"id": {"targetCompleted":{"label":"//foo:bar"}}}`)},
+		{name: "JSONWithWhitespace", give: []byte("\n  {\"id\": {\"targetCompleted\":{\"label\":\"//foo:bar\"}}}\n")},
+		{name: "Binary", give: binaryBuildEvents("//foo:bar")},
+		{name: "Empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for ev, err := range ParseBuildEventsFile(bytes.NewReader(tt.give)) {
+				require.NoError(t, err)
+				got = append(got, ev.ID.TargetCompleted.Label)
+			}
+			if len(tt.give) == 0 {
+				assert.Empty(t, got)
+				return
+			}
+			assert.Equal(t, []string{"//foo:bar"}, got)
+		})
+	}
+}
+
+// writeFakeBazel writes a script which acts like 'bazel build', writing a
+// build event for //foo:bar and exiting with the given exit code.
+func writeFakeBazel(t *testing.T, exitCode int) string {
+	t.Helper()
+
+	script := fmt.Sprintf(`#!/bin/sh
+for arg in "$@"; do
+	case "$arg" in
+	--build_event_json_file=*)
+		echo '{"id":{"targetCompleted":{"label":"//foo:bar"}}}' > "${arg#*=}"
+		;;
+	esac
+done
+exit %d
+`, exitCode)
+
+	path := filepath.Join(t.TempDir(), "bazel")
+	require.NoError(t, os.WriteFile(path, []byte(script), 0o755))
+	return path
+}
+
+func TestClient_BuildEventOutput(t *testing.T) {
+	tests := []struct {
+		name       string
+		exitCode   int
+		wantLabels []string
+		wantErr    error
+	}{
+		{
+			name:       "Success",
+			wantLabels: []string{"//foo:bar"},
+		},
+		{
+			name:       "BuildFailed",
+			exitCode:   1,
+			wantLabels: []string{"//foo:bar"},
+			wantErr:    ErrBuildFailed,
+		},
+		{
+			name:     "InvalidArguments",
+			exitCode: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient(writeFakeBazel(t, tt.exitCode), t.TempDir(), io.Discard)
+
+			var labels []string
+			var err error
+			for ev, evErr := range c.BuildEventOutput(t.Context(), "", "//...") {
+				if evErr != nil {
+					err = evErr
+					continue
+				}
+				labels = append(labels, ev.ID.Label())
+			}
+
+			assert.Equal(t, tt.wantLabels, labels)
+			switch {
+			case tt.exitCode == 0:
+				assert.NoError(t, err)
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			default:
+				assert.ErrorContains(t, err, "failed to build")
+				assert.NotErrorIs(t, err, ErrBuildFailed)
+			}
+		})
+	}
+}
+
+func TestClient_RunScript(t *testing.T) {
+	fake := `#!/bin/sh
+for arg in "$@"; do
+	case "$arg" in
+	--script_path=*)
+		echo "$*" > "${arg#*=}"
+		;;
+	esac
+done
+`
+	bazelPath := filepath.Join(t.TempDir(), "bazel")
+	require.NoError(t, os.WriteFile(bazelPath, []byte(fake), 0o755))
+
+	scriptPath := filepath.Join(t.TempDir(), "run.sh")
+	c := NewClient(bazelPath, t.TempDir(), io.Discard)
+	require.NoError(t, c.RunScript(t.Context(), "/tmp/bazelrc", scriptPath, "//foo:bar"))
+
+	// the fake writes its arguments as the script
+	script, err := os.ReadFile(scriptPath)
+	require.NoError(t, err)
+	assert.Equal(t, "--bazelrc=/tmp/bazelrc run --script_path="+scriptPath+" //foo:bar\n", string(script))
+
+	err = NewClient(writeFakeBazel(t, 1), t.TempDir(), io.Discard).RunScript(t.Context(), "", scriptPath, "//foo:bar")
+	assert.ErrorContains(t, err, "failed to build //foo:bar")
+}
@@ -3,26 +3,66 @@
 package bazel
 
 type BuildEventOutput struct {
-	ID struct {
-		NamedSet struct {
-			ID string `json:"id"`
-		}
-		TargetCompleted struct {
-			Label string `json:"label"`
-		}
-	}
+	ID BuildEventID
 
 	NamedSetOfFiles NamedSetOfFiles `json:"namedSetOfFiles"`
 
-	Completed struct {
-		Success      bool `json:"success"`
-		OutputGroups []struct {
-			Name     string `json:"name"`
-			FileSets []struct {
-				ID string `json:"id"`
-			} `json:"fileSets"`
-		} `json:"outputGroup"`
+	Completed TargetComplete
+
+	// Aborted is set if the event was not produced normally,
+	// e.g. a target which failed to analyze or was skipped.
+	Aborted *Aborted `json:"aborted"`
+
+	// Finished is set for the last event of a build.
+	Finished *BuildFinished `json:"finished"`
+
+	// Started is set for the first event of a build.
+	Started *BuildStarted `json:"started"`
+}
+
+type BuildEventID struct {
+	NamedSet          NamedSetOfFilesID
+	TargetCompleted   TargetCompletedID
+	TargetConfigured  LabelID
+	ConfiguredLabel   LabelID
+	UnconfiguredLabel LabelID
+}
+
+// Label returns the label of the target which the event is about, if any.
+func (id BuildEventID) Label() string {
+	for _, label := range []string{
+		id.TargetCompleted.Label,
+		id.TargetConfigured.Label,
+		id.ConfiguredLabel.Label,
+		id.UnconfiguredLabel.Label,
+	} {
+		if label != "" {
+			return label
+		}
 	}
+	return ""
+}
+
+type NamedSetOfFilesID struct {
+	ID string `json:"id"`
+}
+
+type TargetCompletedID struct {
+	Label string `json:"label"`
+}
+
+type LabelID struct {
+	Label string `json:"label"`
+}
+
+type TargetComplete struct {
+	Success      bool          `json:"success"`
+	OutputGroups []OutputGroup `json:"outputGroup"`
+}
+
+type OutputGroup struct {
+	Name     string                   `json:"name"`
+	FileSets []NamedSetOfFilesFileSet `json:"fileSets"`
 }
 
 type NamedSetOfFiles struct {
@@ -33,8 +73,31 @@ type NamedSetOfFiles struct {
 type NamedSetOfFilesFile struct {
 	Name string `json:"name"`
 	URI  string `json:"uri"`
+
+	// Digest is the digest of the file's contents, if known.
+	// Requires a remote cache or remote execution.
+	Digest string `json:"digest"`
 }
 
 type NamedSetOfFilesFileSet struct {
 	ID string `json:"id"`
 }
+
+type Aborted struct {
+	// Reason is the name of the reason, e.g. ANALYSIS_FAILURE or SKIPPED.
+	// Empty if unknown.
+	Reason      string `json:"reason"`
+	Description string `json:"description"`
+}
+
+type BuildStarted struct {
+	// BuildToolVersion is the version of Bazel, e.g. "7.4.1".
+	BuildToolVersion string `json:"buildToolVersion"`
+}
+
+type BuildFinished struct {
+	ExitCode struct {
+		Name string `json:"name"`
+		Code int    `json:"code"`
+	} `json:"exitCode"`
+}
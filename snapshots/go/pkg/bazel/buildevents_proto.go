@@ -0,0 +1,295 @@
+/* Copyright 2022 Cognite AS */
+
+package bazel
+
+import (
+	"fmt"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// BuildEventTypeURL is the type of the build events sent by Bazel to a Build
+// Event Service, wrapped in a google.protobuf.Any.
+const BuildEventTypeURL = "type.googleapis.com/build_event_stream.BuildEvent"
+
+// Field numbers in build_event_stream.proto, for the parts of the build
+// events used by snapshots.
+const (
+	buildEventIDField              = 1
+	buildEventAbortedField         = 4
+	buildEventStartedField         = 5
+	buildEventCompletedField       = 8
+	buildEventFinishedField        = 14
+	buildEventNamedSetOfFilesField = 15
+
+	buildEventIDTargetCompletedField   = 5
+	buildEventIDNamedSetField          = 13
+	buildEventIDTargetConfiguredField  = 16
+	buildEventIDUnconfiguredLabelField = 19
+	buildEventIDConfiguredLabelField   = 21
+
+	// the label is the first field in all ids with a label
+	labelIDLabelField      = 1
+	namedSetOfFilesIDField = 1
+
+	namedSetOfFilesFilesField    = 1
+	namedSetOfFilesFileSetsField = 2
+
+	fileNameField   = 1
+	fileURIField    = 2
+	fileDigestField = 5
+
+	targetCompleteSuccessField     = 1
+	targetCompleteOutputGroupField = 2
+
+	outputGroupNameField     = 1
+	outputGroupFileSetsField = 3
+
+	abortedReasonField      = 1
+	abortedDescriptionField = 2
+
+	buildStartedBuildToolVersionField = 3
+
+	buildFinishedExitCodeField = 3
+	exitCodeNameField          = 1
+	exitCodeCodeField          = 2
+)
+
+// abortReasons are the names of the values of Aborted.AbortReason.
+// The unknown reason has no name, like in the JSON format.
+var abortReasons = []string{
+	0:  "",
+	1:  "USER_INTERRUPTED",
+	2:  "TIME_OUT",
+	3:  "REMOTE_ENVIRONMENT_FAILURE",
+	4:  "INTERNAL",
+	5:  "LOADING_FAILURE",
+	6:  "ANALYSIS_FAILURE",
+	7:  "SKIPPED",
+	8:  "NO_ANALYZE",
+	9:  "NO_BUILD",
+	10: "INCOMPLETE",
+	11: "OUT_OF_MEMORY",
+}
+
+// UnmarshalBuildEvent parses a build event in the binary protobuf format
+// (build_event_stream.BuildEvent), as used by the Build Event Service.
+//
+// Snapshots only needs a few of the fields, so instead of depending on the
+// generated code for Bazel's protos, the relevant fields are decoded
+// directly. Everything else is skipped.
+func UnmarshalBuildEvent(b []byte) (BuildEventOutput, error) {
+	var ev BuildEventOutput
+	err := walkMessage(b, func(f field) error {
+		switch f.num {
+		case buildEventIDField:
+			return walkMessage(f.bytes, func(f field) error {
+				switch f.num {
+				case buildEventIDNamedSetField:
+					return walkMessage(f.bytes, func(f field) error {
+						if f.num == namedSetOfFilesIDField {
+							ev.ID.NamedSet.ID = string(f.bytes)
+						}
+						return nil
+					})
+				case buildEventIDTargetCompletedField:
+					return unmarshalLabel(f.bytes, &ev.ID.TargetCompleted.Label)
+				case buildEventIDTargetConfiguredField:
+					return unmarshalLabel(f.bytes, &ev.ID.TargetConfigured.Label)
+				case buildEventIDConfiguredLabelField:
+					return unmarshalLabel(f.bytes, &ev.ID.ConfiguredLabel.Label)
+				case buildEventIDUnconfiguredLabelField:
+					return unmarshalLabel(f.bytes, &ev.ID.UnconfiguredLabel.Label)
+				}
+				return nil
+			})
+
+		case buildEventAbortedField:
+			ev.Aborted = &Aborted{}
+			return unmarshalAborted(f.bytes, ev.Aborted)
+
+		case buildEventStartedField:
+			ev.Started = &BuildStarted{}
+			return walkMessage(f.bytes, func(f field) error {
+				if f.num == buildStartedBuildToolVersionField {
+					ev.Started.BuildToolVersion = string(f.bytes)
+				}
+				return nil
+			})
+
+		case buildEventFinishedField:
+			ev.Finished = &BuildFinished{}
+			return unmarshalBuildFinished(f.bytes, ev.Finished)
+
+		case buildEventNamedSetOfFilesField:
+			return unmarshalNamedSetOfFiles(f.bytes, &ev.NamedSetOfFiles)
+
+		case buildEventCompletedField:
+			return unmarshalTargetComplete(f.bytes, &ev.Completed)
+		}
+		return nil
+	})
+	if err != nil {
+		return BuildEventOutput{}, fmt.Errorf("error parsing build event: %w", err)
+	}
+
+	return ev, nil
+}
+
+func unmarshalLabel(b []byte, label *string) error {
+	return walkMessage(b, func(f field) error {
+		if f.num == labelIDLabelField {
+			*label = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func unmarshalAborted(b []byte, aborted *Aborted) error {
+	return walkMessage(b, func(f field) error {
+		switch f.num {
+		case abortedReasonField:
+			if f.varint < uint64(len(abortReasons)) {
+				aborted.Reason = abortReasons[f.varint]
+			} else {
+				aborted.Reason = strconv.FormatUint(f.varint, 10)
+			}
+		case abortedDescriptionField:
+			aborted.Description = string(f.bytes)
+		}
+		return nil
+	})
+}
+
+func unmarshalBuildFinished(b []byte, finished *BuildFinished) error {
+	return walkMessage(b, func(f field) error {
+		if f.num != buildFinishedExitCodeField {
+			return nil
+		}
+		return walkMessage(f.bytes, func(f field) error {
+			switch f.num {
+			case exitCodeNameField:
+				finished.ExitCode.Name = string(f.bytes)
+			case exitCodeCodeField:
+				finished.ExitCode.Code = int(int32(f.varint))
+			}
+			return nil
+		})
+	})
+}
+
+func unmarshalNamedSetOfFiles(b []byte, ns *NamedSetOfFiles) error {
+	return walkMessage(b, func(f field) error {
+		switch f.num {
+		case namedSetOfFilesFilesField:
+			var file NamedSetOfFilesFile
+			err := walkMessage(f.bytes, func(f field) error {
+				switch f.num {
+				case fileNameField:
+					file.Name = string(f.bytes)
+				case fileURIField:
+					file.URI = string(f.bytes)
+				case fileDigestField:
+					file.Digest = string(f.bytes)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ns.Files = append(ns.Files, file)
+
+		case namedSetOfFilesFileSetsField:
+			fileSet, err := unmarshalFileSet(f.bytes)
+			if err != nil {
+				return err
+			}
+			ns.FileSets = append(ns.FileSets, fileSet)
+		}
+		return nil
+	})
+}
+
+func unmarshalTargetComplete(b []byte, tc *TargetComplete) error {
+	return walkMessage(b, func(f field) error {
+		switch f.num {
+		case targetCompleteSuccessField:
+			tc.Success = f.varint != 0
+
+		case targetCompleteOutputGroupField:
+			var group OutputGroup
+			err := walkMessage(f.bytes, func(f field) error {
+				switch f.num {
+				case outputGroupNameField:
+					group.Name = string(f.bytes)
+				case outputGroupFileSetsField:
+					fileSet, err := unmarshalFileSet(f.bytes)
+					if err != nil {
+						return err
+					}
+					group.FileSets = append(group.FileSets, fileSet)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			tc.OutputGroups = append(tc.OutputGroups, group)
+		}
+		return nil
+	})
+}
+
+func unmarshalFileSet(b []byte) (NamedSetOfFilesFileSet, error) {
+	var fileSet NamedSetOfFilesFileSet
+	err := walkMessage(b, func(f field) error {
+		if f.num == namedSetOfFilesIDField {
+			fileSet.ID = string(f.bytes)
+		}
+		return nil
+	})
+	return fileSet, err
+}
+
+// field is a single field of an encoded protobuf message.
+// Only one of bytes (for length-delimited fields) and varint is set.
+type field struct {
+	num    protowire.Number
+	bytes  []byte
+	varint uint64
+}
+
+// walkMessage calls fn for every length-delimited and varint field in the
+// encoded message b, in order. Fields of other types are skipped.
+func walkMessage(b []byte, fn func(field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := field{num: num}
+		switch typ {
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType && typ != protowire.VarintType {
+			continue
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
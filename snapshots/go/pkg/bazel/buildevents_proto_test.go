@@ -0,0 +1,143 @@
+package bazel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func appendBytesField(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendStringField(b []byte, num protowire.Number, v string) []byte {
+	return appendBytesField(b, num, []byte(v))
+}
+
+func TestUnmarshalBuildEvent(t *testing.T) {
+	t.Run("NamedSetOfFiles", func(t *testing.T) {
+		id := appendBytesField(nil, buildEventIDNamedSetField,
+			appendStringField(nil, namedSetOfFilesIDField, "1"))
+
+		var file []byte
+		file = appendStringField(file, fileNameField, "foo.json")
+		file = appendStringField(file, fileURIField, "bytestream://host/blobs/abc/3")
+		file = appendStringField(file, fileDigestField, "abc")
+
+		var ns []byte
+		ns = appendBytesField(ns, namedSetOfFilesFilesField, file)
+		ns = appendBytesField(ns, namedSetOfFilesFileSetsField,
+			appendStringField(nil, namedSetOfFilesIDField, "0"))
+
+		var b []byte
+		b = appendBytesField(b, buildEventIDField, id)
+		b = appendBytesField(b, buildEventNamedSetOfFilesField, ns)
+
+		got, err := UnmarshalBuildEvent(b)
+		require.NoError(t, err)
+		assert.Equal(t, "1", got.ID.NamedSet.ID)
+		assert.Equal(t, NamedSetOfFiles{
+			Files: []NamedSetOfFilesFile{
+				{Name: "foo.json", URI: "bytestream://host/blobs/abc/3", Digest: "abc"},
+			},
+			FileSets: []NamedSetOfFilesFileSet{{ID: "0"}},
+		}, got.NamedSetOfFiles)
+	})
+
+	t.Run("TargetCompleted", func(t *testing.T) {
+		id := appendBytesField(nil, buildEventIDTargetCompletedField,
+			appendStringField(nil, labelIDLabelField, "//foo:bar"))
+
+		var group []byte
+		group = appendStringField(group, outputGroupNameField, "change_track_files")
+		group = appendBytesField(group, outputGroupFileSetsField,
+			appendStringField(nil, namedSetOfFilesIDField, "1"))
+
+		var completed []byte
+		completed = protowire.AppendTag(completed, targetCompleteSuccessField, protowire.VarintType)
+		completed = protowire.AppendVarint(completed, 1)
+		completed = appendBytesField(completed, targetCompleteOutputGroupField, group)
+
+		var b []byte
+		b = appendBytesField(b, buildEventIDField, id)
+		// unknown fields of all types are skipped
+		b = protowire.AppendTag(b, 100, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, 42)
+		b = appendStringField(b, 101, "unknown")
+		b = appendBytesField(b, buildEventCompletedField, completed)
+
+		got, err := UnmarshalBuildEvent(b)
+		require.NoError(t, err)
+		assert.Equal(t, "//foo:bar", got.ID.TargetCompleted.Label)
+		assert.Equal(t, TargetComplete{
+			Success: true,
+			OutputGroups: []OutputGroup{
+				{
+					Name:     "change_track_files",
+					FileSets: []NamedSetOfFilesFileSet{{ID: "1"}},
+				},
+			},
+		}, got.Completed)
+	})
+
+	t.Run("Aborted", func(t *testing.T) {
+		id := appendBytesField(nil, buildEventIDConfiguredLabelField,
+			appendStringField(nil, labelIDLabelField, "//foo:bar"))
+
+		var aborted []byte
+		aborted = protowire.AppendTag(aborted, abortedReasonField, protowire.VarintType)
+		aborted = protowire.AppendVarint(aborted, 6)
+		aborted = appendStringField(aborted, abortedDescriptionField, "analysis failed")
+
+		var b []byte
+		b = appendBytesField(b, buildEventIDField, id)
+		b = appendBytesField(b, buildEventAbortedField, aborted)
+
+		got, err := UnmarshalBuildEvent(b)
+		require.NoError(t, err)
+		assert.Equal(t, "//foo:bar", got.ID.Label())
+		assert.Equal(t, &Aborted{Reason: "ANALYSIS_FAILURE", Description: "analysis failed"}, got.Aborted)
+		assert.Nil(t, got.Finished)
+	})
+
+	t.Run("Finished", func(t *testing.T) {
+		var exitCode []byte
+		exitCode = appendStringField(exitCode, exitCodeNameField, "BUILD_FAILURE")
+		exitCode = protowire.AppendTag(exitCode, exitCodeCodeField, protowire.VarintType)
+		exitCode = protowire.AppendVarint(exitCode, 1)
+
+		b := appendBytesField(nil, buildEventFinishedField,
+			appendBytesField(nil, buildFinishedExitCodeField, exitCode))
+
+		got, err := UnmarshalBuildEvent(b)
+		require.NoError(t, err)
+		require.NotNil(t, got.Finished)
+		assert.Equal(t, "BUILD_FAILURE", got.Finished.ExitCode.Name)
+		assert.Equal(t, 1, got.Finished.ExitCode.Code)
+		assert.Nil(t, got.Aborted)
+		assert.Empty(t, got.ID.Label())
+	})
+
+	t.Run("Started", func(t *testing.T) {
+		var started []byte
+		started = appendStringField(started, 1, "some-uuid")
+		started = appendStringField(started, buildStartedBuildToolVersionField, "7.4.1")
+
+		b := appendBytesField(nil, buildEventStartedField, started)
+
+		got, err := UnmarshalBuildEvent(b)
+		require.NoError(t, err)
+		assert.Equal(t, &BuildStarted{BuildToolVersion: "7.4.1"}, got.Started)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		b := appendBytesField(nil, buildEventIDField,
+			appendStringField(nil, buildEventIDTargetCompletedField, "//foo:bar"))
+
+		_, err := UnmarshalBuildEvent(b[:len(b)-2])
+		assert.ErrorContains(t, err, "error parsing build event")
+	})
+}
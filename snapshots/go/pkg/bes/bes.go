@@ -0,0 +1,235 @@
+/* Copyright 2022 Cognite AS */
+
+// package bes implements a Build Event Service which Bazel can stream its
+// build events to (see Bazel's --bes_backend), so snapshots can be collected
+// from a build without running Bazel again.
+package bes
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"net"
+	"sync"
+
+	buildpb "google.golang.org/genproto/googleapis/devtools/build/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/bazel"
+)
+
+// Invocation is a single Bazel invocation which streamed its build events
+// to the server.
+type Invocation struct {
+	BuildID      string
+	InvocationID string
+
+	events []bazel.BuildEventOutput
+}
+
+// Events returns an iterator over the build events of the invocation,
+// in the order they were sent by Bazel.
+func (inv *Invocation) Events() iter.Seq2[bazel.BuildEventOutput, error] {
+	return func(yield func(bazel.BuildEventOutput, error) bool) {
+		for _, ev := range inv.events {
+			if !yield(ev, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Server implements the PublishBuildEvent service.
+//
+// The build events of an invocation are buffered until Bazel has finished
+// streaming them, and the invocation is then available from Next. If the
+// stream is interrupted, Bazel retries by opening a new stream for the same
+// invocation, continuing from the last acknowledged event.
+//
+// It is safe for concurrent use.
+type Server struct {
+	buildpb.UnimplementedPublishBuildEventServer
+
+	grpcServer *grpc.Server
+
+	mu       sync.Mutex
+	streams  map[streamKey]*stream // unfinished streams
+	finished []*Invocation         // not yet returned by Next
+	ready    chan struct{}         // signals that finished is non-empty
+}
+
+type streamKey struct {
+	buildID      string
+	invocationID string
+}
+
+// stream holds the events received so far for an invocation.
+type stream struct {
+	invocation *Invocation
+	sequence   int64 // last received sequence number
+	finished   bool
+}
+
+func NewServer(opts ...grpc.ServerOption) *Server {
+	s := &Server{
+		grpcServer: grpc.NewServer(opts...),
+		streams:    make(map[streamKey]*stream),
+		ready:      make(chan struct{}, 1),
+	}
+	buildpb.RegisterPublishBuildEventServer(s.grpcServer, s)
+	return s
+}
+
+// Serve accepts connections on lis until Stop is called.
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpcServer.Serve(lis)
+}
+
+// Stop stops the server, waiting for ongoing streams to finish.
+func (s *Server) Stop() {
+	s.grpcServer.GracefulStop()
+}
+
+// Next waits for the next invocation which has finished streaming its
+// build events.
+func (s *Server) Next(ctx context.Context) (*Invocation, error) {
+	for {
+		s.mu.Lock()
+		if len(s.finished) > 0 {
+			inv := s.finished[0]
+			s.finished = s.finished[1:]
+			s.mu.Unlock()
+			return inv, nil
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.ready:
+		}
+	}
+}
+
+func (s *Server) PublishLifecycleEvent(ctx context.Context, req *buildpb.PublishLifecycleEventRequest) (*emptypb.Empty, error) {
+	// lifecycle events don't contain anything snapshots needs
+	return &emptypb.Empty{}, nil
+}
+
+func (s *Server) PublishBuildToolEventStream(srv buildpb.PublishBuildEvent_PublishBuildToolEventStreamServer) error {
+	var st *stream
+	for {
+		req, err := srv.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		obe := req.GetOrderedBuildEvent()
+		streamID := obe.GetStreamId()
+		if st == nil {
+			st = s.stream(streamKey{
+				buildID:      streamID.GetBuildId(),
+				invocationID: streamID.GetInvocationId(),
+			})
+		}
+
+		if err := s.receive(st, obe); err != nil {
+			return err
+		}
+
+		if err := srv.Send(&buildpb.PublishBuildToolEventStreamResponse{
+			StreamId:       streamID,
+			SequenceNumber: obe.GetSequenceNumber(),
+		}); err != nil {
+			return err
+		}
+	}
+
+	if st != nil {
+		s.finish(st)
+	}
+	return nil
+}
+
+// stream finds the stream for an invocation, or starts a new one.
+func (s *Server) stream(key streamKey) *stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.streams[key]
+	if !ok {
+		st = &stream{
+			invocation: &Invocation{
+				BuildID:      key.buildID,
+				InvocationID: key.invocationID,
+			},
+		}
+		s.streams[key] = st
+	}
+	return st
+}
+
+// receive adds an event to the stream.
+func (s *Server) receive(st *stream, obe *buildpb.OrderedBuildEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch seq := obe.GetSequenceNumber(); {
+	case seq <= st.sequence:
+		return nil // already received before a retry
+	case seq != st.sequence+1:
+		return status.Errorf(codes.FailedPrecondition, "expected sequence number %d, got %d", st.sequence+1, seq)
+	}
+	st.sequence++
+
+	event := obe.GetEvent()
+	if event.GetComponentStreamFinished() != nil {
+		st.finished = true
+		return nil
+	}
+
+	bazelEvent := event.GetBazelEvent()
+	if bazelEvent.GetTypeUrl() != bazel.BuildEventTypeURL {
+		return nil // not an event from Bazel's build event protocol
+	}
+
+	ev, err := bazel.UnmarshalBuildEvent(bazelEvent.GetValue())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	st.invocation.events = append(st.invocation.events, ev)
+	return nil
+}
+
+// finish makes the invocation available from Next, once all its events
+// have been received.
+func (s *Server) finish(st *stream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !st.finished {
+		return // Bazel will retry with a new stream
+	}
+
+	key := streamKey{
+		buildID:      st.invocation.BuildID,
+		invocationID: st.invocation.InvocationID,
+	}
+	if s.streams[key] != st {
+		return // already finished by another stream
+	}
+	delete(s.streams, key)
+
+	s.finished = append(s.finished, st.invocation)
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,193 @@
+package bes
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	buildpb "google.golang.org/genproto/googleapis/devtools/build/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/bazel"
+)
+
+func newTestClient(t *testing.T) (*Server, buildpb.PublishBuildEventClient) {
+	lis := bufconn.Listen(1024 * 1024)
+	s := NewServer()
+	t.Cleanup(s.grpcServer.Stop)
+	go func() {
+		_ = s.Serve(lis)
+	}()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return s, buildpb.NewPublishBuildEventClient(conn)
+}
+
+// targetCompletedEvent encodes a build_event_stream.BuildEvent for a
+// completed target.
+func targetCompletedEvent(label string) *buildpb.BuildEvent {
+	var id []byte
+	id = protowire.AppendTag(id, 1, protowire.BytesType)
+	id = protowire.AppendString(id, label)
+
+	var eventID []byte
+	eventID = protowire.AppendTag(eventID, 5, protowire.BytesType)
+	eventID = protowire.AppendBytes(eventID, id)
+
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, eventID)
+
+	return &buildpb.BuildEvent{
+		Event: &buildpb.BuildEvent_BazelEvent{
+			BazelEvent: &anypb.Any{TypeUrl: bazel.BuildEventTypeURL, Value: b},
+		},
+	}
+}
+
+func streamFinishedEvent() *buildpb.BuildEvent {
+	return &buildpb.BuildEvent{
+		Event: &buildpb.BuildEvent_ComponentStreamFinished{
+			ComponentStreamFinished: &buildpb.BuildEvent_BuildComponentStreamFinished{
+				Type: buildpb.BuildEvent_BuildComponentStreamFinished_FINISHED,
+			},
+		},
+	}
+}
+
+// publish streams the events for an invocation, starting at sequence number
+// start, and returns the acknowledged sequence numbers.
+func publish(t *testing.T, client buildpb.PublishBuildEventClient, invocationID string, start int64, events ...*buildpb.BuildEvent) []int64 {
+	t.Helper()
+
+	stream, err := client.PublishBuildToolEventStream(t.Context())
+	require.NoError(t, err)
+
+	streamID := &buildpb.StreamId{
+		BuildId:      "build-" + invocationID,
+		InvocationId: invocationID,
+		Component:    buildpb.StreamId_TOOL,
+	}
+	for i, ev := range events {
+		require.NoError(t, stream.Send(&buildpb.PublishBuildToolEventStreamRequest{
+			OrderedBuildEvent: &buildpb.OrderedBuildEvent{
+				StreamId:       streamID,
+				SequenceNumber: start + int64(i),
+				Event:          ev,
+			},
+		}))
+	}
+	require.NoError(t, stream.CloseSend())
+
+	var acks []int64
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			break
+		}
+		acks = append(acks, resp.GetSequenceNumber())
+	}
+	return acks
+}
+
+func labels(t *testing.T, inv *Invocation) []string {
+	t.Helper()
+
+	var labels []string
+	for ev, err := range inv.Events() {
+		require.NoError(t, err)
+		labels = append(labels, ev.ID.TargetCompleted.Label)
+	}
+	return labels
+}
+
+func TestServer(t *testing.T) {
+	s, client := newTestClient(t)
+
+	acks := publish(t, client, "inv-1", 1,
+		targetCompletedEvent("//foo:bar"),
+		targetCompletedEvent("//foo:baz"),
+		streamFinishedEvent(),
+	)
+	assert.Equal(t, []int64{1, 2, 3}, acks)
+
+	inv, err := s.Next(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "inv-1", inv.InvocationID)
+	assert.Equal(t, "build-inv-1", inv.BuildID)
+	assert.Equal(t, []string{"//foo:bar", "//foo:baz"}, labels(t, inv))
+}
+
+func TestServer_retry(t *testing.T) {
+	s, client := newTestClient(t)
+
+	// the first stream is interrupted before it's finished
+	publish(t, client, "inv-1", 1,
+		targetCompletedEvent("//foo:bar"),
+		targetCompletedEvent("//foo:baz"),
+	)
+
+	// Bazel retries, resending an event
+	publish(t, client, "inv-1", 2,
+		targetCompletedEvent("//foo:baz"),
+		targetCompletedEvent("//foo:qux"),
+		streamFinishedEvent(),
+	)
+
+	inv, err := s.Next(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"//foo:bar", "//foo:baz", "//foo:qux"}, labels(t, inv))
+}
+
+func TestServer_unfinished(t *testing.T) {
+	s, client := newTestClient(t)
+
+	publish(t, client, "inv-1", 1, targetCompletedEvent("//foo:bar"))
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	_, err := s.Next(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestServer_invalidSequence(t *testing.T) {
+	s, client := newTestClient(t)
+
+	acks := publish(t, client, "inv-1", 2, targetCompletedEvent("//foo:bar"))
+	assert.Empty(t, acks)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assert.Empty(t, s.finished)
+}
+
+func TestServer_multipleInvocations(t *testing.T) {
+	s, client := newTestClient(t)
+
+	publish(t, client, "inv-1", 1, targetCompletedEvent("//foo:bar"), streamFinishedEvent())
+	publish(t, client, "inv-2", 1, targetCompletedEvent("//foo:baz"), streamFinishedEvent())
+
+	inv, err := s.Next(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "inv-1", inv.InvocationID)
+
+	inv, err = s.Next(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, "inv-2", inv.InvocationID)
+	assert.Equal(t, []string{"//foo:baz"}, labels(t, inv))
+}
@@ -6,14 +6,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
+	"maps"
+	"net/http"
 	"net/url"
 	"os"
-	"strings"
 
-	"google.golang.org/genproto/googleapis/bytestream"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
 )
 
 var (
@@ -34,15 +32,14 @@ type DelegatingBazelCache struct {
 	caches map[string]BazelCache
 }
 
-func NewDefaultDelegatingCache(credentials string, dialOptions ...grpc.DialOption) BazelCache {
+func NewDefaultDelegatingCache(credentials Credentials, httpClient *http.Client, dialOptions ...grpc.DialOption) BazelCache {
+	httpCache := NewHTTPBazelCache(credentials, httpClient)
 	return &DelegatingBazelCache{
 		caches: map[string]BazelCache{
-			"file": &FileBazelCache{},
-			"bytestream": &RemoteBazelCache{
-				clients:     make(map[string]bytestream.ByteStreamClient),
-				credentials: credentials,
-				DialOptions: dialOptions,
-			},
+			"file":       &FileBazelCache{},
+			"bytestream": NewCASBazelCache(credentials, dialOptions...),
+			"http":       httpCache,
+			"https":      httpCache,
 		},
 	}
 }
@@ -61,6 +58,44 @@ func (c *DelegatingBazelCache) Read(ctx context.Context, secure bool, uri string
 	return cache.Read(ctx, secure, uri)
 }
 
+// ReadBatch reads the given uris with the caches appropriate for them. Caches
+// which can't read batches (see BatchBazelCache) read one item at a time.
+func (c *DelegatingBazelCache) ReadBatch(ctx context.Context, secure bool, uris []string) (map[string][]byte, error) {
+	byScheme := make(map[string][]string) // scheme -> uris
+	for _, uri := range uris {
+		u, err := url.ParseRequestURI(uri)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse scheme for %s: %w", uri, ErrScheme)
+		}
+		if _, ok := c.caches[u.Scheme]; !ok {
+			return nil, fmt.Errorf("unknown scheme %s: %w", u.Scheme, ErrScheme)
+		}
+		byScheme[u.Scheme] = append(byScheme[u.Scheme], uri)
+	}
+
+	contents := make(map[string][]byte, len(uris))
+	for scheme, schemeURIs := range byScheme {
+		if batchCache, ok := c.caches[scheme].(BatchBazelCache); ok {
+			batchContents, err := batchCache.ReadBatch(ctx, secure, schemeURIs)
+			if err != nil {
+				return nil, err
+			}
+			maps.Copy(contents, batchContents)
+			continue
+		}
+
+		for _, uri := range schemeURIs {
+			content, err := c.caches[scheme].Read(ctx, secure, uri)
+			if err != nil {
+				return nil, err
+			}
+			contents[uri] = content
+		}
+	}
+
+	return contents, nil
+}
+
 // FileBazelCache provides access to cached items with 'file://' uris.
 type FileBazelCache struct{}
 
@@ -83,59 +118,3 @@ func (c *FileBazelCache) Read(ctx context.Context, secure bool, uri string) ([]b
 
 	return contents, nil
 }
-
-// RemoteBazelCache provides access to cached items with 'bytestream://' uris.
-type RemoteBazelCache struct {
-	clients     map[string]bytestream.ByteStreamClient
-	credentials string
-	DialOptions []grpc.DialOption
-}
-
-func (c *RemoteBazelCache) Read(ctx context.Context, secure bool, uri string) ([]byte, error) {
-	u, err := url.ParseRequestURI(uri)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse scheme for %s: %w", uri, ErrScheme)
-	}
-
-	// obtain a client
-	client, ok := c.clients[u.Host]
-	if !ok {
-		conn, err := DialTargetWithOptions(uri, secure, c.credentials, c.DialOptions...)
-		if err != nil {
-			return nil, fmt.Errorf("failed to dial host %s: %w", u.Host, err)
-		}
-		client = bytestream.NewByteStreamClient(conn)
-		c.clients[u.Host] = client
-	}
-
-	req := &bytestream.ReadRequest{
-		ResourceName: strings.TrimPrefix(u.RequestURI(), "/"),
-		ReadOffset:   0,
-		ReadLimit:    0,
-	}
-
-	bsrc, err := client.Read(ctx, req)
-	if err != nil {
-		return nil, err
-	}
-
-	var blob []byte
-	for {
-		resp, err := bsrc.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			if grpc.Code(err) == codes.NotFound {
-				return nil, fmt.Errorf("item %s not found: %w", uri, ErrUnavailable)
-			}
-			return nil, fmt.Errorf("failed reading cache response for %s: %w", uri, err)
-		}
-		if resp == nil {
-			return nil, fmt.Errorf("got nil response")
-		}
-		blob = append(blob, resp.Data...)
-	}
-
-	return blob, nil
-}
@@ -0,0 +1,416 @@
+/* Copyright 2022 Cognite AS */
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// BatchBazelCache is a BazelCache which can read many items at once.
+type BatchBazelCache interface {
+	BazelCache
+
+	// ReadBatch reads all the given uris, and returns their contents by uri.
+	// Fails if any of the items can't be read.
+	ReadBatch(ctx context.Context, secure bool, uris []string) (map[string][]byte, error)
+}
+
+var (
+	_ BatchBazelCache = (*DelegatingBazelCache)(nil)
+	_ BatchBazelCache = (*CASBazelCache)(nil)
+)
+
+// defaultMaxBatchSize is the batch size limit used when the server does not
+// announce one. It's the default maximum message size in gRPC.
+const defaultMaxBatchSize = 4 * 1024 * 1024
+
+// batchEntryOverhead is the estimated size of the response framing for each
+// blob in a batch, in addition to the blob itself.
+const batchEntryOverhead = 128
+
+// CASBazelCache provides access to cached items with 'bytestream://' uris
+// through the Remote Execution API's ContentAddressableStorage.
+//
+// Items are read in batches with BatchReadBlobs, grouped by host and
+// instance. Items larger than the server's maximum batch size (see
+// Capabilities.GetCapabilities) are read with ByteStream instead, as are
+// all items from servers which don't support batch reads.
+//
+// It is safe for concurrent use.
+type CASBazelCache struct {
+	credentials Credentials
+	DialOptions []grpc.DialOption
+
+	mu    sync.Mutex          // guards hosts
+	hosts map[string]*casHost // by host
+}
+
+func NewCASBazelCache(credentials Credentials, dialOptions ...grpc.DialOption) *CASBazelCache {
+	return &CASBazelCache{
+		credentials: credentials,
+		DialOptions: dialOptions,
+		hosts:       make(map[string]*casHost),
+	}
+}
+
+// casHost holds the clients for a single remote cache host.
+type casHost struct {
+	bytestream   bytestream.ByteStreamClient
+	cas          repb.ContentAddressableStorageClient
+	capabilities repb.CapabilitiesClient
+
+	mu            sync.Mutex       // guards maxBatchSizes
+	maxBatchSizes map[string]int64 // by instance name
+}
+
+// casBlob is a blob referenced by a 'bytestream://' uri.
+type casBlob struct {
+	host     string
+	instance string
+	digest   *repb.Digest
+}
+
+// parseCASBlob parses a uri of the form
+// bytestream://host/[instance/]blobs/hash/size
+func parseCASBlob(u *url.URL) (casBlob, bool) {
+	parts := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	if len(parts) < 3 || parts[len(parts)-3] != "blobs" {
+		return casBlob{}, false
+	}
+
+	size, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+	if err != nil || size < 0 {
+		return casBlob{}, false
+	}
+
+	return casBlob{
+		host:     u.Host,
+		instance: strings.Join(parts[:len(parts)-3], "/"),
+		digest: &repb.Digest{
+			Hash:      parts[len(parts)-2],
+			SizeBytes: size,
+		},
+	}, true
+}
+
+func (c *CASBazelCache) Read(ctx context.Context, secure bool, uri string) ([]byte, error) {
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse scheme for %s: %w", uri, ErrScheme)
+	}
+
+	if u.Scheme != "bytestream" {
+		return nil, fmt.Errorf("expected scheme to be bytestream, not %s: %w", u.Scheme, ErrScheme)
+	}
+
+	host, err := c.host(uri, u.Host, secure)
+	if err != nil {
+		return nil, err
+	}
+
+	return readByteStream(ctx, host.bytestream, uri, u)
+}
+
+// readByteStream reads the resource identified by the 'bytestream://' uri
+// (already parsed as u) in full.
+func readByteStream(ctx context.Context, client bytestream.ByteStreamClient, uri string, u *url.URL) ([]byte, error) {
+	req := &bytestream.ReadRequest{
+		ResourceName: strings.TrimPrefix(u.RequestURI(), "/"),
+		ReadOffset:   0,
+		ReadLimit:    0,
+	}
+
+	bsrc, err := client.Read(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var blob []byte
+	for {
+		resp, err := bsrc.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if grpc.Code(err) == codes.NotFound {
+				return nil, fmt.Errorf("item %s not found: %w", uri, ErrUnavailable)
+			}
+			return nil, fmt.Errorf("failed reading cache response for %s: %w", uri, err)
+		}
+		if resp == nil {
+			return nil, fmt.Errorf("got nil response")
+		}
+		blob = append(blob, resp.Data...)
+	}
+
+	return blob, nil
+}
+
+func (c *CASBazelCache) ReadBatch(ctx context.Context, secure bool, uris []string) (map[string][]byte, error) {
+	type batchKey struct {
+		host     string
+		instance string
+	}
+
+	// blobGroup is the blobs to read from a single instance on a host.
+	// Blobs with the same content can be referenced by many uris,
+	// so they're deduplicated by digest.
+	type blobGroup struct {
+		digests []*repb.Digest
+		uris    map[string][]string // digest ID -> uris
+	}
+
+	// group the blobs by host and instance,
+	// and read anything which isn't a blob with ByteStream
+	contents := make(map[string][]byte, len(uris))
+	groups := make(map[batchKey]*blobGroup)
+	for _, uri := range uris {
+		u, err := url.ParseRequestURI(uri)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse scheme for %s: %w", uri, ErrScheme)
+		}
+
+		blob, ok := parseCASBlob(u)
+		if !ok {
+			content, err := c.Read(ctx, secure, uri)
+			if err != nil {
+				return nil, err
+			}
+			contents[uri] = content
+			continue
+		}
+
+		key := batchKey{host: blob.host, instance: blob.instance}
+		group, ok := groups[key]
+		if !ok {
+			group = &blobGroup{uris: make(map[string][]string)}
+			groups[key] = group
+		}
+
+		id := digestID(blob.digest)
+		if _, ok := group.uris[id]; !ok {
+			group.digests = append(group.digests, blob.digest)
+		}
+		group.uris[id] = append(group.uris[id], uri)
+	}
+
+	for key, group := range groups {
+		uriOf := func(d *repb.Digest) string {
+			if uris, ok := group.uris[digestID(d)]; ok {
+				return uris[0]
+			}
+			return digestID(d)
+		}
+
+		host, err := c.host(uriOf(group.digests[0]), key.host, secure)
+		if err != nil {
+			return nil, err
+		}
+
+		blobContents, err := c.readBlobs(ctx, host, key.instance, group.digests, uriOf)
+		if err != nil {
+			return nil, err
+		}
+
+		for id, content := range blobContents {
+			for _, uri := range group.uris[id] {
+				contents[uri] = content
+			}
+		}
+	}
+
+	return contents, nil
+}
+
+// readBlobs reads the given blobs from an instance on a host, returning the
+// contents by digest ID (see digestID). uriOf is used to find a uri for
+// a requested digest, for reading with ByteStream and for error messages.
+func (c *CASBazelCache) readBlobs(ctx context.Context, host *casHost, instance string, digests []*repb.Digest, uriOf func(*repb.Digest) string) (map[string][]byte, error) {
+	contents := make(map[string][]byte, len(digests))
+
+	readByteStreamBlob := func(d *repb.Digest) error {
+		uri := uriOf(d)
+		u, err := url.ParseRequestURI(uri)
+		if err != nil {
+			return fmt.Errorf("failed to parse scheme for %s: %w", uri, ErrScheme)
+		}
+		content, err := readByteStream(ctx, host.bytestream, uri, u)
+		if err != nil {
+			return err
+		}
+		contents[digestID(d)] = content
+		return nil
+	}
+
+	maxBatchSize := host.maxBatchSize(ctx, instance)
+
+	var batch []*repb.Digest
+	var batchSize int64
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := c.readBatch(ctx, host, instance, batch, uriOf, contents)
+		if status.Code(err) == codes.Unimplemented {
+			// The server announced batch reads, but doesn't support them.
+			host.disableBatches(instance)
+			for _, d := range batch {
+				if err := readByteStreamBlob(d); err != nil {
+					return err
+				}
+			}
+		} else if err != nil {
+			return err
+		}
+		batch, batchSize = nil, 0
+		return nil
+	}
+
+	for _, d := range digests {
+		size := d.SizeBytes + batchEntryOverhead
+		if maxBatchSize <= 0 || size > maxBatchSize {
+			// too large for a batch, or batches are unsupported
+			if err := readByteStreamBlob(d); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if batchSize+size > maxBatchSize {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+		batch = append(batch, d)
+		batchSize += size
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return contents, nil
+}
+
+// readBatch reads a single batch of blobs with BatchReadBlobs into contents.
+func (c *CASBazelCache) readBatch(ctx context.Context, host *casHost, instance string, digests []*repb.Digest, uriOf func(*repb.Digest) string, contents map[string][]byte) error {
+	resp, err := host.cas.BatchReadBlobs(ctx, &repb.BatchReadBlobsRequest{
+		InstanceName: instance,
+		Digests:      digests,
+	})
+	if err != nil {
+		return err
+	}
+
+	requested := make(map[string]bool, len(digests))
+	for _, d := range digests {
+		requested[digestID(d)] = true
+	}
+
+	for _, r := range resp.GetResponses() {
+		if !requested[digestID(r.GetDigest())] {
+			return fmt.Errorf("cache responded with unrequested digest %s: %w", digestID(r.GetDigest()), ErrUnavailable)
+		}
+		uri := uriOf(r.GetDigest())
+		switch code := codes.Code(r.GetStatus().GetCode()); code {
+		case codes.OK:
+			contents[digestID(r.GetDigest())] = r.GetData()
+		case codes.NotFound:
+			return fmt.Errorf("item %s not found: %w", uri, ErrUnavailable)
+		default:
+			return fmt.Errorf("failed reading cache response for %s: %w", uri, status.ErrorProto(r.GetStatus()))
+		}
+	}
+
+	// the server must respond for every digest
+	for _, d := range digests {
+		if _, ok := contents[digestID(d)]; !ok {
+			return fmt.Errorf("no cache response for %s: %w", uriOf(d), ErrUnavailable)
+		}
+	}
+
+	return nil
+}
+
+// host obtains the clients for the given host, dialing it if needed.
+func (c *CASBazelCache) host(uri, host string, secure bool) (*casHost, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if h, ok := c.hosts[host]; ok {
+		return h, nil
+	}
+
+	conn, err := dialCache(uri, secure, c.credentials, c.DialOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial host %s: %w", host, err)
+	}
+
+	h := &casHost{
+		bytestream:    bytestream.NewByteStreamClient(conn),
+		cas:           repb.NewContentAddressableStorageClient(conn),
+		capabilities:  repb.NewCapabilitiesClient(conn),
+		maxBatchSizes: make(map[string]int64),
+	}
+	c.hosts[host] = h
+	return h, nil
+}
+
+// maxBatchSize returns the maximum total size of a batch read from the
+// instance, as announced by the server. Returns 0 if batch reads can't be
+// used with this instance.
+func (h *casHost) maxBatchSize(ctx context.Context, instance string) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if size, ok := h.maxBatchSizes[instance]; ok {
+		return size
+	}
+
+	var size int64
+	caps, err := h.capabilities.GetCapabilities(ctx, &repb.GetCapabilitiesRequest{
+		InstanceName: instance,
+	})
+	switch {
+	case err != nil:
+		// Not a fatal error: the server might only support ByteStream.
+		// Don't remember the result if we were canceled.
+		if ctx.Err() != nil {
+			return 0
+		}
+	case caps.GetCacheCapabilities() == nil:
+		// no cache capabilities, so no batch reads
+	case caps.GetCacheCapabilities().GetMaxBatchTotalSizeBytes() == 0:
+		size = defaultMaxBatchSize // no limit set by server
+	default:
+		size = caps.GetCacheCapabilities().GetMaxBatchTotalSizeBytes()
+	}
+
+	h.maxBatchSizes[instance] = size
+	return size
+}
+
+// disableBatches stops any further batch reads from the instance.
+func (h *casHost) disableBatches(instance string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.maxBatchSizes[instance] = 0
+}
+
+// digestID is a comparable identifier for a digest.
+func digestID(d *repb.Digest) string {
+	return fmt.Sprintf("%s/%d", d.GetHash(), d.GetSizeBytes())
+}
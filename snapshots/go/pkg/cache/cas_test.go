@@ -0,0 +1,358 @@
+/* Copyright 2022 Cognite AS */
+
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	repb "github.com/bazelbuild/remote-apis/build/bazel/remote/execution/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// mockCASServer is an in-process stand-in for a remote cache,
+// implementing ByteStream, ContentAddressableStorage and Capabilities.
+type mockCASServer struct {
+	repb.UnimplementedContentAddressableStorageServer
+	repb.UnimplementedCapabilitiesServer
+	bytestream.UnimplementedByteStreamServer
+
+	maxBatchSize   int64
+	noCapabilities bool
+	noBatches      bool
+
+	// extraResponses are added to every BatchReadBlobs response.
+	extraResponses []*repb.BatchReadBlobsResponse_Response
+
+	mu              sync.Mutex
+	blobs           map[string][]byte // instance/hash -> content
+	batchRequests   []*repb.BatchReadBlobsRequest
+	byteStreamReads []string
+}
+
+func (s *mockCASServer) put(instance string, content string) string {
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+	s.blobs[instance+"/"+hash] = []byte(content)
+	return strings.TrimPrefix(fmt.Sprintf("%s/blobs/%s/%d", instance, hash, len(content)), "/")
+}
+
+func (s *mockCASServer) GetCapabilities(ctx context.Context, req *repb.GetCapabilitiesRequest) (*repb.ServerCapabilities, error) {
+	if s.noCapabilities {
+		return nil, grpcstatus.Error(codes.Unimplemented, "no capabilities")
+	}
+
+	return &repb.ServerCapabilities{
+		CacheCapabilities: &repb.CacheCapabilities{
+			MaxBatchTotalSizeBytes: s.maxBatchSize,
+		},
+	}, nil
+}
+
+func (s *mockCASServer) BatchReadBlobs(ctx context.Context, req *repb.BatchReadBlobsRequest) (*repb.BatchReadBlobsResponse, error) {
+	if s.noBatches {
+		return nil, grpcstatus.Error(codes.Unimplemented, "no batches")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batchRequests = append(s.batchRequests, req)
+
+	resp := &repb.BatchReadBlobsResponse{}
+	for _, d := range req.GetDigests() {
+		r := &repb.BatchReadBlobsResponse_Response{
+			Digest: d,
+			Status: &status.Status{Code: int32(codes.OK)},
+		}
+		if content, ok := s.blobs[req.GetInstanceName()+"/"+d.GetHash()]; ok {
+			r.Data = content
+		} else {
+			r.Status.Code = int32(codes.NotFound)
+		}
+		resp.Responses = append(resp.Responses, r)
+	}
+	resp.Responses = append(resp.Responses, s.extraResponses...)
+
+	return resp, nil
+}
+
+func (s *mockCASServer) Read(req *bytestream.ReadRequest, stream bytestream.ByteStream_ReadServer) error {
+	s.mu.Lock()
+	s.byteStreamReads = append(s.byteStreamReads, req.GetResourceName())
+	s.mu.Unlock()
+
+	// [instance/]blobs/hash/size
+	parts := strings.Split(req.GetResourceName(), "/")
+	if len(parts) < 3 {
+		return grpcstatus.Errorf(codes.NotFound, "not a blob: %s", req.GetResourceName())
+	}
+	instance := strings.Join(parts[:len(parts)-3], "/")
+	content, ok := s.blobs[instance+"/"+parts[len(parts)-2]]
+	if !ok {
+		return grpcstatus.Errorf(codes.NotFound, "not found: %s", req.GetResourceName())
+	}
+
+	return stream.Send(&bytestream.ReadResponse{Data: content})
+}
+
+func newTestCASBazelCache(t *testing.T, srv *mockCASServer) *CASBazelCache {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	repb.RegisterContentAddressableStorageServer(s, srv)
+	repb.RegisterCapabilitiesServer(s, srv)
+	bytestream.RegisterByteStreamServer(s, srv)
+	t.Cleanup(s.Stop)
+
+	go func() {
+		_ = s.Serve(lis)
+	}()
+
+	bufDialer := func(ctx context.Context, address string) (net.Conn, error) {
+		return lis.Dial()
+	}
+	return NewCASBazelCache(nil,
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(bufDialer),
+	)
+}
+
+func TestCASBazelCache_Read(t *testing.T) {
+	srv := &mockCASServer{blobs: make(map[string][]byte)}
+	c := newTestCASBazelCache(t, srv)
+	ctx := t.Context()
+
+	var contents []byte
+	var err error
+
+	// invalid scheme
+	contents, err = c.Read(ctx, false, "no-scheme")
+	require.ErrorIs(t, err, ErrScheme)
+	require.Nil(t, contents)
+
+	// wrong scheme
+	contents, err = c.Read(ctx, false, "file://some-file")
+	require.ErrorIs(t, err, ErrScheme)
+	require.Nil(t, contents)
+
+	// wrong cache key
+	contents, err = c.Read(ctx, false, "bytestream://bufnet/wrong-key")
+	require.ErrorIs(t, err, ErrUnavailable)
+	require.Nil(t, contents)
+
+	// good request
+	contents, err = c.Read(ctx, false, "bytestream://bufnet/"+srv.put("", "hello world"))
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(contents))
+}
+
+func TestCASBazelCache_concurrent(t *testing.T) {
+	srv := &mockCASServer{
+		maxBatchSize: 1024,
+		blobs:        make(map[string][]byte),
+	}
+	c := newTestCASBazelCache(t, srv)
+	blob := srv.put("", "hello world")
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Go(func() {
+			// spread the reads over a few hosts,
+			// so that clients are created concurrently
+			uri := fmt.Sprintf("bytestream://bufnet-%d/%s", i%5, blob)
+			var contents []byte
+			var err error
+			if i%2 == 0 {
+				contents, err = c.Read(t.Context(), false, uri)
+			} else {
+				var batch map[string][]byte
+				batch, err = c.ReadBatch(t.Context(), false, []string{uri})
+				contents = batch[uri]
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "hello world", string(contents))
+		})
+	}
+	wg.Wait()
+
+	assert.Len(t, c.hosts, 5)
+}
+
+func TestCASBazelCache_ReadBatch(t *testing.T) {
+	srv := &mockCASServer{
+		maxBatchSize: 1024,
+		blobs:        make(map[string][]byte),
+	}
+	c := newTestCASBazelCache(t, srv)
+
+	uris := []string{
+		"bytestream://bufnet/" + srv.put("", "foo"),
+		"bytestream://bufnet/" + srv.put("", "bar"),
+		"bytestream://bufnet/" + srv.put("instance/name", "baz"),
+	}
+	// the same blob referenced twice
+	uris = append(uris, uris[0])
+
+	contents, err := c.ReadBatch(t.Context(), false, uris)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string][]byte{
+		uris[0]: []byte("foo"),
+		uris[1]: []byte("bar"),
+		uris[2]: []byte("baz"),
+	}, contents)
+
+	// one batch per instance, and no duplicates in the batches
+	require.Len(t, srv.batchRequests, 2)
+	var instances []string
+	var digests int
+	for _, req := range srv.batchRequests {
+		instances = append(instances, req.GetInstanceName())
+		digests += len(req.GetDigests())
+	}
+	assert.ElementsMatch(t, []string{"", "instance/name"}, instances)
+	assert.Equal(t, 3, digests)
+	assert.Empty(t, srv.byteStreamReads)
+}
+
+func TestCASBazelCache_ReadBatch_largeBlobs(t *testing.T) {
+	srv := &mockCASServer{
+		maxBatchSize: 3 * batchEntryOverhead,
+		blobs:        make(map[string][]byte),
+	}
+	c := newTestCASBazelCache(t, srv)
+
+	large := strings.Repeat("x", 3*batchEntryOverhead)
+	uris := []string{
+		"bytestream://bufnet/" + srv.put("", "small-1"),
+		"bytestream://bufnet/" + srv.put("", "small-2"),
+		"bytestream://bufnet/" + srv.put("", "small-3"),
+		"bytestream://bufnet/" + srv.put("", large),
+	}
+
+	contents, err := c.ReadBatch(t.Context(), false, uris)
+	require.NoError(t, err)
+	assert.Equal(t, large, string(contents[uris[3]]))
+	assert.Equal(t, "small-3", string(contents[uris[2]]))
+
+	// the small blobs don't fit in a single batch,
+	// and the large blob is read with ByteStream
+	assert.Len(t, srv.batchRequests, 2)
+	assert.Equal(t, []string{strings.TrimPrefix(uris[3], "bytestream://bufnet/")}, srv.byteStreamReads)
+}
+
+func TestCASBazelCache_ReadBatch_noBatches(t *testing.T) {
+	tests := []struct {
+		name string
+		srv  *mockCASServer
+	}{
+		{
+			name: "NoCapabilities",
+			srv:  &mockCASServer{noCapabilities: true},
+		},
+		{
+			name: "NoBatchReadBlobs",
+			srv:  &mockCASServer{noBatches: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.srv.blobs = make(map[string][]byte)
+			c := newTestCASBazelCache(t, tt.srv)
+
+			uris := []string{
+				"bytestream://bufnet/" + tt.srv.put("", "foo"),
+				"bytestream://bufnet/" + tt.srv.put("", "bar"),
+			}
+
+			contents, err := c.ReadBatch(t.Context(), false, uris)
+			require.NoError(t, err)
+			assert.Equal(t, "foo", string(contents[uris[0]]))
+			assert.Equal(t, "bar", string(contents[uris[1]]))
+			assert.Len(t, tt.srv.byteStreamReads, 2)
+		})
+	}
+}
+
+func TestCASBazelCache_ReadBatch_errors(t *testing.T) {
+	srv := &mockCASServer{blobs: make(map[string][]byte)}
+	c := newTestCASBazelCache(t, srv)
+
+	t.Run("NotFound", func(t *testing.T) {
+		uris := []string{
+			"bytestream://bufnet/" + srv.put("", "foo"),
+			"bytestream://bufnet/blobs/0000/4",
+		}
+
+		_, err := c.ReadBatch(t.Context(), false, uris)
+		require.ErrorIs(t, err, ErrUnavailable)
+		assert.ErrorContains(t, err, "blobs/0000/4")
+	})
+
+	t.Run("UnrequestedDigest", func(t *testing.T) {
+		for _, digest := range []*repb.Digest{{Hash: "1111", SizeBytes: 4}, nil} {
+			srv := &mockCASServer{
+				maxBatchSize: 1024,
+				blobs:        make(map[string][]byte),
+				extraResponses: []*repb.BatchReadBlobsResponse_Response{{
+					Digest: digest,
+					Status: &status.Status{Code: int32(codes.OK)},
+				}},
+			}
+			c := newTestCASBazelCache(t, srv)
+
+			_, err := c.ReadBatch(t.Context(), false, []string{"bytestream://bufnet/" + srv.put("", "foo")})
+			require.ErrorIs(t, err, ErrUnavailable)
+			assert.ErrorContains(t, err, "unrequested digest")
+		}
+	})
+
+	t.Run("InvalidScheme", func(t *testing.T) {
+		_, err := c.ReadBatch(t.Context(), false, []string{"no-scheme"})
+		require.ErrorIs(t, err, ErrScheme)
+	})
+}
+
+func TestParseCASBlob(t *testing.T) {
+	tests := []struct {
+		give     string
+		wantOK   bool
+		instance string
+		hash     string
+		size     int64
+	}{
+		{give: "bytestream://host/blobs/abc/12", wantOK: true, hash: "abc", size: 12},
+		{give: "bytestream://host:1234/some/instance/blobs/abc/12", wantOK: true, instance: "some/instance", hash: "abc", size: 12},
+		{give: "bytestream://host/blobs/abc/notasize"},
+		{give: "bytestream://host/blobs/abc"},
+		{give: "bytestream://host/cache-key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.give, func(t *testing.T) {
+			u, err := url.ParseRequestURI(tt.give)
+			require.NoError(t, err)
+
+			blob, ok := parseCASBlob(u)
+			require.Equal(t, tt.wantOK, ok)
+			if !ok {
+				return
+			}
+			assert.Equal(t, tt.instance, blob.instance)
+			assert.Equal(t, tt.hash, blob.digest.GetHash())
+			assert.Equal(t, tt.size, blob.digest.GetSizeBytes())
+		})
+	}
+}
@@ -0,0 +1,96 @@
+/* Copyright 2022 Cognite AS */
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// Credentials provides the headers used to authenticate requests to caches,
+// e.g. from a credential helper (see Bazel's --credential_helper).
+// Implementations must be safe for concurrent use.
+type Credentials interface {
+	// Headers returns the headers to add to requests to the cache at uri.
+	// No headers means that requests are not authenticated.
+	Headers(ctx context.Context, uri string) (map[string][]string, error)
+}
+
+// credentialsURI returns the uri to get credentials for when accessing the
+// item at u. Credentials apply to a cache as a whole, so this is only the
+// scheme and host.
+func credentialsURI(u *url.URL, secure bool) string {
+	scheme := u.Scheme
+	if scheme != "http" && scheme != "https" {
+		// e.g. bytestream
+		scheme = "http"
+		if secure {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + u.Host
+}
+
+// dialCache connects to the gRPC cache which has the item at uri.
+// With credentials, the uri's user info is only used for requests for which
+// there are no headers from the credentials.
+func dialCache(uri string, secure bool, credentials Credentials, dialOptions []grpc.DialOption) (*grpc.ClientConn, error) {
+	if credentials == nil {
+		return DialTargetWithOptions(uri, secure, "", dialOptions...)
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse uri %s: %w", uri, ErrScheme)
+	}
+
+	rpcCredentials := &headerRPCCredentials{
+		credentials: credentials,
+		uri:         credentialsURI(u, secure),
+	}
+	if u.User != nil {
+		rpcCredentials.fallback = u.User.String()
+		u.User = nil
+	}
+
+	dialOptions = append(slices.Clip(dialOptions), grpc.WithPerRPCCredentials(rpcCredentials))
+	return DialTargetWithOptions(u.String(), secure, "", dialOptions...)
+}
+
+// headerRPCCredentials adds headers from Credentials as metadata to gRPC
+// requests.
+type headerRPCCredentials struct {
+	credentials Credentials
+	uri         string
+
+	// fallback is the authorization used if there are no headers
+	fallback string
+}
+
+func (c *headerRPCCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	headers, err := c.credentials.Headers(ctx, c.uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials for %s: %w", c.uri, err)
+	}
+
+	md := make(map[string]string, len(headers))
+	for key, values := range headers {
+		// gRPC metadata keys are lowercase
+		md[strings.ToLower(key)] = strings.Join(values, ",")
+	}
+
+	if len(md) == 0 && c.fallback != "" {
+		md["authorization"] = c.fallback
+	}
+
+	return md, nil
+}
+
+func (c *headerRPCCredentials) RequireTransportSecurity() bool {
+	return false
+}
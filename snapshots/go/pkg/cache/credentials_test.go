@@ -0,0 +1,125 @@
+/* Copyright 2022 Cognite AS */
+
+package cache
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/bytestream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// staticCredentials provides the same headers for all uris.
+type staticCredentials map[string][]string
+
+func (c staticCredentials) Headers(ctx context.Context, uri string) (map[string][]string, error) {
+	return c, nil
+}
+
+// metadataByteStreamServer records the metadata of incoming requests.
+type metadataByteStreamServer struct {
+	bytestream.UnimplementedByteStreamServer
+
+	md metadata.MD
+}
+
+func (s *metadataByteStreamServer) Read(req *bytestream.ReadRequest, stream bytestream.ByteStream_ReadServer) error {
+	s.md, _ = metadata.FromIncomingContext(stream.Context())
+	return stream.Send(&bytestream.ReadResponse{Data: []byte("hello world")})
+}
+
+func TestCredentials_gRPC(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := &metadataByteStreamServer{}
+	s := grpc.NewServer()
+	bytestream.RegisterByteStreamServer(s, srv)
+	defer s.Stop()
+	go func() {
+		_ = s.Serve(lis)
+	}()
+
+	dialOptions := []grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+	}
+
+	tests := []struct {
+		name        string
+		credentials Credentials
+		uri         string
+		want        map[string][]string
+	}{
+		{
+			name: "Headers",
+			credentials: staticCredentials{
+				"Authorization": {"Bearer some-token"},
+				"X-Other":       {"a", "b"},
+			},
+			uri: "bytestream://bufnet/cache-key",
+			want: map[string][]string{
+				"authorization": {"Bearer some-token"},
+				"x-other":       {"a,b"},
+			},
+		},
+		{
+			name: "HeadersOverrideUserInfo",
+			credentials: staticCredentials{
+				"Authorization": {"Bearer some-token"},
+			},
+			uri: "bytestream://user@bufnet/cache-key",
+			want: map[string][]string{
+				"authorization": {"Bearer some-token"},
+			},
+		},
+		{
+			name:        "UserInfoWithoutHeaders",
+			credentials: staticCredentials{},
+			uri:         "bytestream://user@bufnet/cache-key",
+			want: map[string][]string{
+				"authorization": {"user"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCASBazelCache(tt.credentials, dialOptions...)
+			contents, err := c.Read(t.Context(), false, tt.uri)
+			require.NoError(t, err)
+			assert.Equal(t, "hello world", string(contents))
+
+			for key, values := range tt.want {
+				assert.Equal(t, values, srv.md.Get(key), "metadata %s", key)
+			}
+		})
+	}
+}
+
+func TestCredentialsURI(t *testing.T) {
+	tests := []struct {
+		give   string
+		secure bool
+		want   string
+	}{
+		{give: "bytestream://cache.example.com:443/blobs/abc/3", secure: true, want: "https://cache.example.com:443"},
+		{give: "bytestream://cache.example.com/blobs/abc/3", secure: false, want: "http://cache.example.com"},
+		{give: "https://cache.example.com/prefix/cas/abc", secure: false, want: "https://cache.example.com"},
+		{give: "http://cache.example.com/cas/abc", secure: true, want: "http://cache.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.give, func(t *testing.T) {
+			u, err := url.Parse(tt.give)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, credentialsURI(u, tt.secure))
+		})
+	}
+}
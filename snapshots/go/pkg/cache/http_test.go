@@ -0,0 +1,144 @@
+/* Copyright 2022 Cognite AS */
+
+package cache
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestHTTPCacheServer(t *testing.T, handler func(r *http.Request)) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler != nil {
+			handler(r)
+		}
+		if r.URL.Path != "/prefix/cas/abc123" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPBazelCache(t *testing.T) {
+	srv := newTestHTTPCacheServer(t, nil)
+	c := NewHTTPBazelCache(nil, nil)
+	ctx := t.Context()
+
+	// invalid scheme
+	contents, err := c.Read(ctx, false, "no-scheme")
+	require.ErrorIs(t, err, ErrScheme)
+	require.Nil(t, contents)
+
+	// wrong scheme
+	contents, err = c.Read(ctx, false, "file:///some-file")
+	require.ErrorIs(t, err, ErrScheme)
+	require.Nil(t, contents)
+
+	// not found
+	contents, err = c.Read(ctx, false, HTTPCacheURI(srv.URL+"/prefix", "wrong-digest"))
+	require.ErrorIs(t, err, ErrUnavailable)
+	require.Nil(t, contents)
+
+	// good request
+	contents, err = c.Read(ctx, false, HTTPCacheURI(srv.URL+"/prefix/", "abc123"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(contents))
+}
+
+func TestHTTPBazelCache_auth(t *testing.T) {
+	var gotAuth string
+	srv := newTestHTTPCacheServer(t, func(r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+	})
+	userURL := strings.Replace(srv.URL, "http://", "http://user:pass@", 1)
+
+	t.Run("BasicAuth", func(t *testing.T) {
+		c := NewHTTPBazelCache(nil, nil)
+		_, err := c.Read(t.Context(), false, HTTPCacheURI(userURL+"/prefix", "abc123"))
+		require.NoError(t, err)
+
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth("user", "pass")
+		assert.Equal(t, req.Header.Get("Authorization"), gotAuth)
+	})
+
+	t.Run("Credentials", func(t *testing.T) {
+		creds := staticCredentials{"Authorization": {"Bearer some-token"}}
+		c := NewHTTPBazelCache(creds, nil)
+		_, err := c.Read(t.Context(), false, HTTPCacheURI(userURL+"/prefix", "abc123"))
+		require.NoError(t, err)
+		assert.Equal(t, "Bearer some-token", gotAuth)
+	})
+
+	t.Run("EmptyCredentials", func(t *testing.T) {
+		c := NewHTTPBazelCache(staticCredentials{}, nil)
+		_, err := c.Read(t.Context(), false, HTTPCacheURI(userURL+"/prefix", "abc123"))
+		require.NoError(t, err)
+
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth("user", "pass")
+		assert.Equal(t, req.Header.Get("Authorization"), gotAuth)
+	})
+
+	t.Run("NoUserInfoInErrors", func(t *testing.T) {
+		c := NewHTTPBazelCache(nil, nil)
+		_, err := c.Read(t.Context(), false, HTTPCacheURI(userURL+"/prefix", "missing"))
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "pass")
+	})
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello world"))
+	}))
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	}), 0o644))
+
+	t.Run("UnknownCA", func(t *testing.T) {
+		client, err := NewHTTPClient(HTTPClientOptions{})
+		require.NoError(t, err)
+
+		_, err = NewHTTPBazelCache(nil, client).Read(t.Context(), false, srv.URL)
+		require.Error(t, err)
+	})
+
+	t.Run("TLSCertificate", func(t *testing.T) {
+		client, err := NewHTTPClient(HTTPClientOptions{TLSCertificate: caFile})
+		require.NoError(t, err)
+
+		contents, err := NewHTTPBazelCache(nil, client).Read(t.Context(), false, srv.URL)
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(contents))
+	})
+
+	t.Run("TLSInsecureSkipVerify", func(t *testing.T) {
+		client, err := NewHTTPClient(HTTPClientOptions{TLSInsecureSkipVerify: true})
+		require.NoError(t, err)
+
+		contents, err := NewHTTPBazelCache(nil, client).Read(t.Context(), false, srv.URL)
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(contents))
+	})
+
+	t.Run("ClientKeyWithoutCertificate", func(t *testing.T) {
+		_, err := NewHTTPClient(HTTPClientOptions{TLSClientKey: "key.pem"})
+		require.Error(t, err)
+	})
+}
@@ -4,13 +4,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"iter"
 	"log"
+	"maps"
 	"os"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/metadata"
 
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/bazel"
@@ -33,9 +39,37 @@ type CollectArgs struct {
 	BazelWorkspacePath     string
 	BazelWriteStderr       bool
 	BazelBuildEventsPath   string
-	CredentialHelper       string
+	BuildEventsBinaryPath  string
+	BazelCacheHTTPURL      string
+	BazelCacheHTTPOptions  cache.HTTPClientOptions
+	CredentialHelpers      []string
+	Jobs                   int
 	OutPath                string
 	NoPrint                bool
+
+	// KeepGoing records the labels which failed to build as unknown in the
+	// snapshot, instead of failing.
+	KeepGoing bool
+
+	// BuildEvents are the build events to collect from, e.g. received by a
+	// Build Event Service. If set, Bazel isn't run.
+	BuildEvents iter.Seq2[bazel.BuildEventOutput, error]
+
+	// KnownTrackers are labels known to be trackers, e.g. those of the
+	// snapshot diffed against. If they fail to build, they're broken (see
+	// KeepGoing), even if Bazel doesn't report that their change_track_files
+	// output group failed, e.g. as they failed analysis. The labels of
+	// BaseSnapshot are known too.
+	KnownTrackers []string
+
+	// BaseSnapshot, if set with KeepGoing, is where the trackers of labels
+	// which failed to build are copied from, marked as carried forward.
+	BaseSnapshot *models.Snapshot
+
+	// SkipGitMetadata leaves out the state of the git repository in the
+	// workspace from the snapshot's metadata, e.g. when collecting from
+	// builds of other checkouts.
+	SkipGitMetadata bool
 }
 
 // collect uses Bazel directly to build and collect all change tracker files to
@@ -43,7 +77,14 @@ type CollectArgs struct {
 // '//...', with the change_track_files output groups, while also capturing
 // build events (see Bazel's --build_event_json_file). It then retrieves all
 // these tracker files, parses them and builds the snapshot.
+//
+// Instead of running Bazel, the build events of a build which has already
+// run can be given with BuildEvents, or read from a file with
+// BazelBuildEventsPath (JSON or binary, detected from the contents) or
+// BuildEventsBinaryPath.
 func (c *collecter) Collect(args *CollectArgs) (*models.Snapshot, error) {
+	start := time.Now()
+
 	bstderr := io.Discard
 	if args.BazelWriteStderr {
 		bstderr = os.Stderr
@@ -51,38 +92,169 @@ func (c *collecter) Collect(args *CollectArgs) (*models.Snapshot, error) {
 
 	ctx := context.Background()
 
-	var credential string
-	if args.CredentialHelper != "" {
-		creds, err := getAuthorization(args.CredentialHelper, args.BazelWorkspacePath)
+	var credentials cache.Credentials
+	if len(args.CredentialHelpers) > 0 {
+		helpers, err := newCredentialHelpers(args.CredentialHelpers, args.BazelWorkspacePath)
 		if err != nil {
-			return nil, fmt.Errorf("error getting credentials: %w", err)
+			return nil, fmt.Errorf("error configuring credential helpers: %w", err)
 		}
-		credential = creds[0]
+		credentials = helpers
+	}
+
+	httpClient, err := cache.NewHTTPClient(args.BazelCacheHTTPOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring http cache client: %w", err)
 	}
 
-	bcache := cache.NewDefaultDelegatingCache(credential)
+	bcache := cache.NewDefaultDelegatingCache(credentials, httpClient)
 
 	// build digests, get the build events
-	log.Printf("collecting digests from %s", args.BazelExpression)
-	bazelArgs := []string{args.BazelExpression, "--output_groups=change_track_files"}
+	buildEvents := args.BuildEvents
+	var query string // only known if bazel is run here
+	switch {
+	case buildEvents != nil:
+		// the build has already run
+	case args.BazelBuildEventsPath != "" || args.BuildEventsBinaryPath != "":
+		path, parse := args.BazelBuildEventsPath, bazel.ParseBuildEventsFile
+		if args.BuildEventsBinaryPath != "" {
+			path, parse = args.BuildEventsBinaryPath, bazel.ParseBuildEventsBinaryFile
+		}
 
-	var buildEvents iter.Seq2[bazel.BuildEventOutput, error]
-	if args.BazelBuildEventsPath != "" {
-		f, err := os.Open(args.BazelBuildEventsPath)
+		f, err := os.Open(path)
 		if err != nil {
 			return nil, err
 		}
 		defer func() { _ = f.Close() }()
 
-		buildEvents = bazel.ParseBuildEventsFile(f)
-	} else {
+		buildEvents = parse(f)
+	default:
+		log.Printf("collecting digests from %s", args.BazelExpression)
+		query = args.BazelExpression
+		bazelArgs := []string{args.BazelExpression, "--output_groups=change_track_files"}
+		if args.KeepGoing {
+			bazelArgs = append(bazelArgs, "--keep_going")
+		}
 		bazelc := bazel.NewClient(args.BazelPath, args.BazelWorkspacePath, bstderr)
 		buildEvents = bazelc.BuildEventOutput(ctx, args.BazelRcPath, bazelArgs...)
 	}
 
+	knownTrackers := make(map[string]bool)
+	for _, label := range args.KnownTrackers {
+		knownTrackers[label] = true
+	}
+	if args.BaseSnapshot != nil {
+		for label := range args.BaseSnapshot.Labels {
+			knownTrackers[label] = true
+		}
+	}
+
+	build, err := readBuildEvents(buildEvents, args.BazelCacheHTTPURL, knownTrackers)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("got %d change trackers", len(build.labelFiles))
+
+	// Without KeepGoing, failed builds are fatal, so nothing is carried
+	// forward.
+	if args.BaseSnapshot != nil && args.KeepGoing {
+		build.carryForward(args.BaseSnapshot)
+	}
+
+	if err := build.check(args.KeepGoing); err != nil {
+		return nil, err
+	}
+
+	// add cache metadata (headers) to requests
+	ctx = metadata.NewOutgoingContext(ctx, createMetadata(args.BazelCacheGrpcMetadata))
+
+	// populate manifest labels
+	trackers, err := readTrackers(ctx, bcache, args.BazelCacheGrpcs, build.labelFiles, args.Jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	for label, tracker := range build.carried {
+		trackers[label] = tracker
+	}
+	for label := range build.broken {
+		trackers[label] = &models.Tracker{Unknown: true}
+	}
+
+	manifest := &models.Snapshot{
+		Version:  models.SnapshotVersion,
+		Metadata: collectMetadata(ctx, args, build, query, start),
+		Labels:   trackers,
+	}
+
+	// should support writing to outfile here, since it can be reused in other commands
+	snapshotJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest JSON: %w", err)
+	}
+
+	if args.OutPath != "" {
+		// write to outpath
+		outFile, err := os.Create(args.OutPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open out path: %w", err)
+		}
+
+		if _, err := io.Copy(outFile, bytes.NewReader(snapshotJSON)); err != nil {
+			return nil, err
+		}
+		log.Printf("wrote file to %s", outFile.Name())
+	}
+
+	if args.OutPath == "" && !args.NoPrint {
+		// write to stdout
+		if _, err := io.Copy(os.Stdout, bytes.NewBuffer(snapshotJSON)); err != nil {
+			return nil, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// ErrBrokenTargets is returned by Collect when targets failed to build,
+// unless KeepGoing is set.
+var ErrBrokenTargets = errors.New("targets failed to build")
+
+// buildResult is what Collect needs from the build events.
+type buildResult struct {
+	labelFiles map[string]string // label -> tracker uri
+	broken     map[string]string // label -> why it failed to build
+	ignored    map[string]string // label -> why it failed to build, for labels which aren't trackers
+	failed     bool              // whether the build as a whole failed
+
+	bazelVersion string // from the build's started event, if any
+
+	// carried are the trackers of broken labels taken from a base snapshot
+	// (see carryForward).
+	carried map[string]*models.Tracker
+}
+
+// readBuildEvents finds the tracker files, and the labels which failed to
+// build, in the build events.
+//
+// Only trackers are broken if they failed to build: labels in knownTrackers,
+// or whose change_track_files output group failed. Other targets which
+// failed are ignored, and those skipped, e.g. as they're incompatible with
+// the platform, are left out.
+func readBuildEvents(buildEvents iter.Seq2[bazel.BuildEventOutput, error], httpCacheURL string, knownTrackers map[string]bool) (*buildResult, error) {
+	build := &buildResult{
+		labelFiles: make(map[string]string),
+		broken:     make(map[string]string),
+		ignored:    make(map[string]string),
+	}
+	trackers := make(map[string]bool)
+	skipped := make(map[string]bool)
+
 	bazelFiles := make(namedSetsOfFiles)
-	labelFiles := make(map[string]string) // label -> uri
 	for event, err := range buildEvents {
+		if errors.Is(err, bazel.ErrBuildFailed) {
+			build.failed = true
+			continue
+		}
 		if err != nil {
 			return nil, fmt.Errorf("error reading build event: %w", err)
 		}
@@ -90,8 +262,35 @@ func (c *collecter) Collect(args *CollectArgs) (*models.Snapshot, error) {
 		case event.ID.NamedSet.ID != "":
 			bazelFiles.Put(event.ID.NamedSet.ID, event.NamedSetOfFiles)
 
+		case event.Aborted != nil:
+			// e.g. failed to analyze, or skipped
+			if label := event.ID.Label(); label != "" {
+				build.broken[label] = abortedReason(event.Aborted)
+				if event.Aborted.Reason == "SKIPPED" {
+					skipped[label] = true
+				}
+			}
+
+		case event.Started != nil:
+			build.bazelVersion = event.Started.BuildToolVersion
+
+		case event.Finished != nil:
+			if event.Finished.ExitCode.Code != 0 {
+				build.failed = true
+			}
+
 		case event.ID.TargetCompleted.Label != "":
 			label := event.ID.TargetCompleted.Label
+			if !event.Completed.Success {
+				build.broken[label] = "failed"
+				if slices.ContainsFunc(event.Completed.OutputGroups, func(group bazel.OutputGroup) bool {
+					return group.Name == "change_track_files"
+				}) {
+					trackers[label] = true
+				}
+				break
+			}
+
 			var labelURI string
 		uriSearch:
 			for _, group := range event.Completed.OutputGroups {
@@ -108,72 +307,212 @@ func (c *collecter) Collect(args *CollectArgs) (*models.Snapshot, error) {
 				// Bazel guarantees that a file set will be
 				// provided before it's referenced.
 				for _, fileSet := range group.FileSets {
-					for uri := range bazelFiles.ByID(fileSet.ID) {
+					for file := range bazelFiles.FilesByID(fileSet.ID) {
 						// change_track_files will
 						// contain only one file per label,
 						// so we can stop at the first one.
-						labelURI = uri
+						labelURI = trackerURI(file, httpCacheURL)
 						break uriSearch
 					}
 				}
 			}
-			if label != "" && labelURI != "" {
-				labelFiles[label] = labelURI
+			if labelURI != "" {
+				build.labelFiles[label] = labelURI
 			}
 		}
 	}
-	log.Printf("got %d change trackers", len(labelFiles))
 
-	manifest := &models.Snapshot{
-		Labels: map[string]*models.Tracker{},
+	// Labels which produced a tracker file have been built,
+	// even if they failed in some other way,
+	// e.g. in another configuration.
+	for label := range build.labelFiles {
+		delete(build.broken, label)
 	}
 
-	// populate manifest labels
-	for label, uri := range labelFiles {
-		// add cache metadata (headers) to request
-		ctx = metadata.NewOutgoingContext(ctx, createMetadata(args.BazelCacheGrpcMetadata))
+	for label, reason := range build.broken {
+		if knownTrackers[label] || trackers[label] {
+			continue
+		}
+		delete(build.broken, label)
+		if !skipped[label] {
+			build.ignored[label] = reason
+		}
+	}
 
-		// retrieve the content from cache
-		trackerContent, err := bcache.Read(ctx, args.BazelCacheGrpcs, uri)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get item %s for label %s from cache: %w", uri, label, err)
+	return build, nil
+}
+
+// carryForward moves the broken labels which have a known tracker in base
+// from broken to carried, copying their trackers.
+func (b *buildResult) carryForward(base *models.Snapshot) {
+	for _, label := range slices.Sorted(maps.Keys(b.broken)) {
+		tracker := base.Labels[label]
+		if tracker == nil || tracker.Unknown {
+			continue
 		}
 
-		tracker := &models.Tracker{}
-		if err := json.Unmarshal(trackerContent, &tracker); err != nil {
-			return nil, fmt.Errorf("invalid tracker content %s: %w", trackerContent, err)
+		carried := *tracker
+		carried.CarriedForward = true
+		if b.carried == nil {
+			b.carried = make(map[string]*models.Tracker)
 		}
+		b.carried[label] = &carried
 
-		manifest.Labels[label] = tracker
+		log.Printf("carrying forward %s from the base snapshot: %s", label, b.broken[label])
+		delete(b.broken, label)
 	}
+}
 
-	// should support writing to outfile here, since it can be reused in other commands
-	snapshotJSON, err := json.MarshalIndent(manifest, "", "  ")
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal manifest JSON: %w", err)
+// check fails if any trackers failed to build, or the build failed, unless
+// keepGoing is set. Then, the failed targets are only logged, but it still
+// fails if the build failed without any failed targets, since it's then
+// unknown what's missing from the build.
+// Targets which have been carried forward don't count as failed.
+func (b *buildResult) check(keepGoing bool) error {
+	if !keepGoing {
+		if len(b.broken) > 0 {
+			return fmt.Errorf("%d %w:%s", len(b.broken), ErrBrokenTargets, describeLabels(b.broken))
+		}
+		if b.failed {
+			return fmt.Errorf("%w%s", bazel.ErrBuildFailed, describeLabels(b.ignored))
+		}
+		return nil
 	}
 
-	if args.OutPath != "" {
-		// write to outpath
-		outFile, err := os.Create(args.OutPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to open out path: %w", err)
-		}
+	if len(b.ignored) > 0 {
+		log.Printf("warning: %d targets which aren't trackers failed to build, ignoring them:%s", len(b.ignored), describeLabels(b.ignored))
+	}
+	if len(b.broken) > 0 {
+		log.Printf("warning: %d %s, recording them as unknown:%s", len(b.broken), ErrBrokenTargets, describeLabels(b.broken))
+	}
 
-		if _, err := io.Copy(outFile, bytes.NewReader(snapshotJSON)); err != nil {
-			return nil, err
+	if b.failed && len(b.broken) == 0 && len(b.carried) == 0 && len(b.ignored) == 0 {
+		return bazel.ErrBuildFailed
+	}
+	return nil
+}
+
+// describeLabels lists labels with why they failed to build, one per line.
+func describeLabels(labels map[string]string) string {
+	var list strings.Builder
+	for _, label := range slices.Sorted(maps.Keys(labels)) {
+		fmt.Fprintf(&list, "\n  %s: %s", label, labels[label])
+	}
+	return list.String()
+}
+
+// abortedReason describes why a target was aborted.
+func abortedReason(aborted *bazel.Aborted) string {
+	var reason string
+	switch aborted.Reason {
+	case "SKIPPED":
+		reason = "skipped"
+	case "":
+		reason = "aborted"
+	default:
+		reason = fmt.Sprintf("aborted (%s)", aborted.Reason)
+	}
+
+	if aborted.Description != "" {
+		reason += ": " + aborted.Description
+	}
+	return reason
+}
+
+// trackerURI returns the uri to retrieve a tracker file from.
+//
+// With an HTTP cache (httpCacheURL), the uris in the build events might not
+// point to the cache (e.g. 'file://' uris for outputs which were never
+// downloaded). Files with a known digest are then retrieved from the HTTP
+// cache by digest instead.
+func trackerURI(file bazel.NamedSetOfFilesFile, httpCacheURL string) string {
+	if httpCacheURL == "" || file.Digest == "" || strings.HasPrefix(file.URI, "bytestream://") {
+		return file.URI
+	}
+	return cache.HTTPCacheURI(httpCacheURL, file.Digest)
+}
+
+// trackerBatchSize is the number of change trackers read at once
+// from caches which support batch reads (see cache.BatchBazelCache).
+const trackerBatchSize = 100
+
+// readTrackers retrieves and parses the tracker files in labelFiles
+// (label -> uri) from the cache, using up to jobs concurrent reads.
+// The first failure cancels all remaining reads.
+func readTrackers(ctx context.Context, bcache cache.BazelCache, secure bool, labelFiles map[string]string, jobs int) (map[string]*models.Tracker, error) {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(jobs, 1))
+
+	batchSize := 1
+	if _, ok := bcache.(cache.BatchBazelCache); ok {
+		batchSize = trackerBatchSize
+	}
+
+	var mu sync.Mutex
+	trackers := make(map[string]*models.Tracker, len(labelFiles))
+	for labels := range slices.Chunk(slices.Sorted(maps.Keys(labelFiles)), batchSize) {
+		if gctx.Err() != nil {
+			break // a read has failed; don't start any more
 		}
-		log.Printf("wrote file to %s", outFile.Name())
+
+		g.Go(func() error {
+			// retrieve the content from cache
+			contents, err := readItems(gctx, bcache, secure, labels, labelFiles)
+			if err != nil {
+				return err
+			}
+
+			for _, label := range labels {
+				trackerContent := contents[labelFiles[label]]
+
+				tracker, err := models.LoadTracker(trackerContent)
+				if err != nil {
+					return fmt.Errorf("invalid tracker content %s: %w", trackerContent, err)
+				}
+
+				mu.Lock()
+				trackers[label] = tracker
+				mu.Unlock()
+			}
+			return nil
+		})
 	}
 
-	if args.OutPath == "" && !args.NoPrint {
-		// write to stdout
-		if _, err := io.Copy(os.Stdout, bytes.NewBuffer(snapshotJSON)); err != nil {
-			return nil, err
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return trackers, nil
+}
+
+// readItems reads the tracker files for the given labels from the cache,
+// returning the contents by uri.
+func readItems(ctx context.Context, bcache cache.BazelCache, secure bool, labels []string, labelFiles map[string]string) (map[string][]byte, error) {
+	if batchCache, ok := bcache.(cache.BatchBazelCache); ok && len(labels) > 1 {
+		uris := make([]string, 0, len(labels))
+		for _, label := range labels {
+			uris = append(uris, labelFiles[label])
 		}
+
+		contents, err := batchCache.ReadBatch(ctx, secure, uris)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get items for %d labels from cache: %w", len(labels), err)
+		}
+		return contents, nil
 	}
 
-	return manifest, nil
+	contents := make(map[string][]byte, len(labels))
+	for _, label := range labels {
+		uri := labelFiles[label]
+		content, err := bcache.Read(ctx, secure, uri)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get item %s for label %s from cache: %w", uri, label, err)
+		}
+		contents[uri] = content
+	}
+	return contents, nil
 }
 
 // namedSetsOfFiles is an in-memory buffer for NamedSetOfFiles.
@@ -188,7 +527,8 @@ func (c *collecter) Collect(args *CollectArgs) (*models.Snapshot, error) {
 // as they are produced by Bazel using Put().
 //
 // To retrieve all files in a NamedSetOfFiles by ID,
-// use ByID(), to get an iterator over the file URIs.
+// use ByID(), to get an iterator over the file URIs,
+// or FilesByID(), to get an iterator over the files.
 type namedSetsOfFiles map[string]bazel.NamedSetOfFiles
 
 func (fs namedSetsOfFiles) Put(id string, ns bazel.NamedSetOfFiles) {
@@ -197,6 +537,16 @@ func (fs namedSetsOfFiles) Put(id string, ns bazel.NamedSetOfFiles) {
 
 func (fs namedSetsOfFiles) ByID(fileSetID string) iter.Seq[string] {
 	return func(yield func(string) bool) {
+		for file := range fs.FilesByID(fileSetID) {
+			if !yield(file.URI) {
+				return
+			}
+		}
+	}
+}
+
+func (fs namedSetsOfFiles) FilesByID(fileSetID string) iter.Seq[bazel.NamedSetOfFilesFile] {
+	return func(yield func(bazel.NamedSetOfFilesFile) bool) {
 		pending := []string{fileSetID}
 		seen := make(map[string]struct{})
 		for len(pending) > 0 {
@@ -209,7 +559,7 @@ func (fs namedSetsOfFiles) ByID(fileSetID string) iter.Seq[string] {
 			seen[currentID] = struct{}{}
 
 			for _, file := range fs[currentID].Files {
-				if !yield(file.URI) {
+				if !yield(file) {
 					return
 				}
 			}
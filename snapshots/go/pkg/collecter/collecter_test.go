@@ -1,11 +1,18 @@
 package collecter
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/bazel"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNamedSetOfFiles(t *testing.T) {
@@ -108,3 +115,341 @@ func TestNamedSetOfFiles(t *testing.T) {
 		})
 	}
 }
+
+// fakeCache is a BazelCache serving contents from a map (uri -> content),
+// recording the maximum number of concurrent reads.
+type fakeCache struct {
+	contents map[string]string
+
+	active    atomic.Int32
+	maxActive atomic.Int32
+	reads     atomic.Int32
+}
+
+func (c *fakeCache) Read(ctx context.Context, secure bool, uri string) ([]byte, error) {
+	c.reads.Add(1)
+	active := c.active.Add(1)
+	defer c.active.Add(-1)
+	for {
+		prev := c.maxActive.Load()
+		if active <= prev || c.maxActive.CompareAndSwap(prev, active) {
+			break
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	content, ok := c.contents[uri]
+	if !ok {
+		return nil, fmt.Errorf("no such item: %s", uri)
+	}
+	return []byte(content), nil
+}
+
+func TestReadTrackers(t *testing.T) {
+	bcache := &fakeCache{contents: map[string]string{}}
+	labelFiles := map[string]string{}
+	for i := range 100 {
+		uri := fmt.Sprintf("file:///tracker-%d.json", i)
+		bcache.contents[uri] = fmt.Sprintf(`{"digest": "digest-%d", "run": ["//:run-%d"]}`, i, i)
+		labelFiles[fmt.Sprintf("//:tracker-%d", i)] = uri
+	}
+
+	trackers, err := readTrackers(t.Context(), bcache, false, labelFiles, 4)
+	require.NoError(t, err)
+
+	require.Len(t, trackers, 100)
+	assert.Equal(t, "digest-42", trackers["//:tracker-42"].Digest)
+	assert.Equal(t, []string{"//:run-42"}, trackers["//:tracker-42"].Run)
+	assert.LessOrEqual(t, bcache.maxActive.Load(), int32(4))
+}
+
+func TestReadTrackers_errors(t *testing.T) {
+	t.Run("missing item", func(t *testing.T) {
+		bcache := &fakeCache{contents: map[string]string{
+			"file:///a.json": `{"digest": "a"}`,
+		}}
+		labelFiles := map[string]string{
+			"//:a": "file:///a.json",
+			"//:b": "file:///b.json",
+		}
+
+		_, err := readTrackers(t.Context(), bcache, false, labelFiles, 2)
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "for label //:b")
+	})
+
+	t.Run("invalid content", func(t *testing.T) {
+		bcache := &fakeCache{contents: map[string]string{
+			"file:///a.json": `not json`,
+		}}
+
+		_, err := readTrackers(t.Context(), bcache, false, map[string]string{"//:a": "file:///a.json"}, 1)
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "invalid tracker content")
+	})
+
+	t.Run("first error cancels", func(t *testing.T) {
+		// Only one item exists, and it is never reached
+		// because the failing reads cancel the rest.
+		bcache := &fakeCache{contents: map[string]string{}}
+		labelFiles := map[string]string{}
+		for i := range 100 {
+			labelFiles[fmt.Sprintf("//:tracker-%d", i)] = fmt.Sprintf("file:///tracker-%d.json", i)
+		}
+
+		_, err := readTrackers(t.Context(), bcache, false, labelFiles, 1)
+		require.Error(t, err)
+		assert.Less(t, bcache.reads.Load(), int32(100))
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		bcache := &fakeCache{contents: map[string]string{
+			"file:///a.json": `{"digest": "a"}`,
+		}}
+		_, err := readTrackers(ctx, bcache, false, map[string]string{"//:a": "file:///a.json"}, 1)
+		assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+	})
+}
+
+// fakeBatchCache is a fakeCache which can also read batches.
+type fakeBatchCache struct {
+	fakeCache
+
+	batches atomic.Int32
+}
+
+func (c *fakeBatchCache) ReadBatch(ctx context.Context, secure bool, uris []string) (map[string][]byte, error) {
+	c.batches.Add(1)
+
+	contents := make(map[string][]byte, len(uris))
+	for _, uri := range uris {
+		content, err := c.Read(ctx, secure, uri)
+		if err != nil {
+			return nil, err
+		}
+		contents[uri] = content
+	}
+	return contents, nil
+}
+
+func TestReadTrackers_batches(t *testing.T) {
+	bcache := &fakeBatchCache{fakeCache: fakeCache{contents: map[string]string{}}}
+	labelFiles := map[string]string{}
+	for i := range 250 {
+		uri := fmt.Sprintf("file:///tracker-%d.json", i)
+		bcache.contents[uri] = fmt.Sprintf(`{"digest": "digest-%d"}`, i)
+		labelFiles[fmt.Sprintf("//:tracker-%d", i)] = uri
+	}
+
+	trackers, err := readTrackers(t.Context(), bcache, false, labelFiles, 4)
+	require.NoError(t, err)
+
+	require.Len(t, trackers, 250)
+	assert.Equal(t, "digest-249", trackers["//:tracker-249"].Digest)
+	assert.Equal(t, int32(3), bcache.batches.Load())
+}
+
+func TestTrackerURI(t *testing.T) {
+	tests := []struct {
+		name         string
+		give         bazel.NamedSetOfFilesFile
+		httpCacheURL string
+		want         string
+	}{
+		{
+			name: "NoHTTPCache",
+			give: bazel.NamedSetOfFilesFile{URI: "file:///a.json", Digest: "abc"},
+			want: "file:///a.json",
+		},
+		{
+			name:         "HTTPCache",
+			give:         bazel.NamedSetOfFilesFile{URI: "file:///a.json", Digest: "abc"},
+			httpCacheURL: "https://cache.example.com/",
+			want:         "https://cache.example.com/cas/abc",
+		},
+		{
+			name:         "HTTPCacheNoDigest",
+			give:         bazel.NamedSetOfFilesFile{URI: "file:///a.json"},
+			httpCacheURL: "https://cache.example.com",
+			want:         "file:///a.json",
+		},
+		{
+			name:         "HTTPCacheByteStream",
+			give:         bazel.NamedSetOfFilesFile{URI: "bytestream://cache/blobs/abc/3", Digest: "abc"},
+			httpCacheURL: "https://cache.example.com",
+			want:         "bytestream://cache/blobs/abc/3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, trackerURI(tt.give, tt.httpCacheURL))
+		})
+	}
+}
+
+func TestReadBuildEvents(t *testing.T) {
+	events := `
+{"id":{"started":{}},"started":{"uuid":"some-uuid","buildToolVersion":"7.4.1"}}
+{"id":{"namedSet":{"id":"0"}},"namedSetOfFiles":{"files":[{"name":"ok.json","uri":"file:///ok.json"}]}}
+{"id":{"targetCompleted":{"label":"//:ok"}},"completed":{"success":true,"outputGroup":[{"name":"change_track_files","fileSets":[{"id":"0"}]}]}}
+{"id":{"targetCompleted":{"label":"//:failed"}},"completed":{"outputGroup":[{"name":"change_track_files","incomplete":true}]}}
+{"id":{"configuredLabel":{"label":"//:analysis"}},"aborted":{"reason":"ANALYSIS_FAILURE","description":"no such attribute"}}
+{"id":{"targetCompleted":{"label":"//:skipped"}},"aborted":{"reason":"SKIPPED"}}
+{"id":{"targetConfigured":{"label":"//:ok"}},"aborted":{}}
+{"id":{"configuredLabel":{"label":"//:tool"}},"aborted":{"reason":"ANALYSIS_FAILURE","description":"oops"}}
+{"id":{"targetCompleted":{"label":"//:binary"}},"completed":{}}
+{"id":{"targetCompleted":{"label":"//:incompatible"}},"aborted":{"reason":"SKIPPED"}}
+{"id":{"buildFinished":{}},"finished":{"exitCode":{"name":"BUILD_FAILURE","code":1}}}
+`
+	known := map[string]bool{"//:analysis": true, "//:skipped": true}
+	build, err := readBuildEvents(bazel.ParseBuildEventsFile(strings.NewReader(events)), "", known)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"//:ok": "file:///ok.json"}, build.labelFiles)
+	assert.Equal(t, map[string]string{
+		"//:failed":   "failed",
+		"//:analysis": "aborted (ANALYSIS_FAILURE): no such attribute",
+		"//:skipped":  "skipped",
+	}, build.broken)
+	// targets which aren't trackers are ignored, or left out if skipped
+	assert.Equal(t, map[string]string{
+		"//:tool":   "aborted (ANALYSIS_FAILURE): oops",
+		"//:binary": "failed",
+	}, build.ignored)
+	assert.True(t, build.failed)
+	assert.Equal(t, "7.4.1", build.bazelVersion)
+}
+
+func TestReadBuildEvents_buildFailed(t *testing.T) {
+	events := func(yield func(bazel.BuildEventOutput, error) bool) {
+		var ev bazel.BuildEventOutput
+		ev.ID.TargetCompleted.Label = "//:failed"
+		if !yield(ev, nil) {
+			return
+		}
+		yield(bazel.BuildEventOutput{}, bazel.ErrBuildFailed)
+	}
+
+	build, err := readBuildEvents(events, "", map[string]bool{"//:failed": true})
+	require.NoError(t, err)
+	assert.True(t, build.failed)
+	assert.Equal(t, map[string]string{"//:failed": "failed"}, build.broken)
+}
+
+func TestBuildResult_check(t *testing.T) {
+	broken := map[string]string{
+		"//:b": "failed",
+		"//:a": "skipped",
+	}
+
+	tests := []struct {
+		name      string
+		give      buildResult
+		keepGoing bool
+		wantErr   error
+		wantMsg   string
+	}{
+		{
+			name: "Success",
+		},
+		{
+			name:    "BrokenTargets",
+			give:    buildResult{broken: broken, failed: true},
+			wantErr: ErrBrokenTargets,
+			wantMsg: "2 targets failed to build:\n  //:a: skipped\n  //:b: failed",
+		},
+		{
+			name:      "BrokenTargetsKeepGoing",
+			give:      buildResult{broken: broken, failed: true},
+			keepGoing: true,
+		},
+		{
+			name:      "BuildFailedWithoutBrokenTargets",
+			give:      buildResult{failed: true},
+			keepGoing: true,
+			wantErr:   bazel.ErrBuildFailed,
+		},
+		{
+			name: "AllCarriedForward",
+			give: buildResult{
+				carried: map[string]*models.Tracker{"//:a": {Digest: "a", CarriedForward: true}},
+				failed:  true,
+			},
+			keepGoing: true,
+		},
+		{
+			name:    "BuildFailed",
+			give:    buildResult{failed: true, ignored: map[string]string{"//:tool": "failed"}},
+			wantErr: bazel.ErrBuildFailed,
+			wantMsg: "bazel build failed\n  //:tool: failed",
+		},
+		{
+			name:      "OnlyIgnoredKeepGoing",
+			give:      buildResult{failed: true, ignored: map[string]string{"//:tool": "failed"}},
+			keepGoing: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.give.check(tt.keepGoing)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantMsg != "" {
+				assert.EqualError(t, err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestBuildResult_carryForward(t *testing.T) {
+	build := buildResult{
+		broken: map[string]string{
+			"//:a": "failed",
+			"//:b": "skipped",
+			"//:c": "failed",
+		},
+	}
+	base := &models.Snapshot{
+		Labels: map[string]*models.Tracker{
+			"//:a": {Digest: "a", Run: []string{"//:deploy-a"}},
+			"//:b": {Unknown: true},
+			"//:d": {Digest: "d"},
+		},
+	}
+
+	build.carryForward(base)
+
+	assert.Equal(t, map[string]*models.Tracker{
+		"//:a": {Digest: "a", Run: []string{"//:deploy-a"}, CarriedForward: true},
+	}, build.carried)
+	assert.Equal(t, map[string]string{"//:b": "skipped", "//:c": "failed"}, build.broken)
+	assert.False(t, base.Labels["//:a"].CarriedForward, "base snapshot was modified")
+}
+
+func TestCollect_baseWithoutKeepGoing(t *testing.T) {
+	events := `
+{"id":{"targetCompleted":{"label":"//:a"}},"completed":{}}
+{"id":{"buildFinished":{}},"finished":{"exitCode":{"name":"BUILD_FAILURE","code":1}}}
+`
+	base := &models.Snapshot{
+		Labels: map[string]*models.Tracker{"//:a": {Digest: "a"}},
+	}
+
+	_, err := NewCollecter().Collect(&CollectArgs{
+		BuildEvents:  bazel.ParseBuildEventsFile(strings.NewReader(events)),
+		BaseSnapshot: base,
+		NoPrint:      true,
+	})
+	assert.ErrorIs(t, err, ErrBrokenTargets)
+}
@@ -3,37 +3,229 @@
 package collecter
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/cache"
+)
+
+const (
+	// credentialHelperTimeout limits how long a single helper invocation
+	// may take (see Bazel's --credential_helper_timeout).
+	credentialHelperTimeout = 10 * time.Second
+
+	// credentialHelperCacheDuration is how long credentials are cached when
+	// the helper doesn't say when they expire (see Bazel's
+	// --credential_helper_cache_duration).
+	credentialHelperCacheDuration = 30 * time.Minute
 )
 
-type Credentials struct {
-	Headers struct {
-		Authorization []string `json:"Authorization"`
-	} `json:"headers"`
+var _ cache.Credentials = (*credentialHelpers)(nil)
+
+// credentialHelpers provides credentials from credential helpers, using the
+// same protocol as Bazel (see
+// https://github.com/EngFlow/credential-helper-spec). A helper is run as
+// '<helper> get', with a request like {"uri": "https://host"} on stdin, and
+// responds with {"headers": {"name": ["value"]}, "expires": "<RFC 3339>"}.
+//
+// Helpers can be scoped to hosts, like Bazel's
+// --credential_helper=<host-pattern>=<path>. An exact host takes precedence
+// over the longest matching wildcard pattern ('*.example.com'), which takes
+// precedence over the helper without a pattern. For the same pattern, the
+// last helper wins.
+//
+// Responses are cached per uri until they expire.
+type credentialHelpers struct {
+	defaultHelper string
+	hosts         map[string]string // host or *.domain pattern -> helper path
+	workspacePath string
+	now           func() time.Time
+
+	mu          sync.Mutex // guards credentials, and serializes helper runs
+	credentials map[string]cachedCredentials
+}
+
+type cachedCredentials struct {
+	headers map[string][]string
+	expires time.Time
+}
+
+type credentialHelperRequest struct {
+	URI string `json:"uri"`
+}
+
+type credentialHelperResponse struct {
+	Headers map[string][]string `json:"headers"`
+	Expires string              `json:"expires"`
+}
+
+// newCredentialHelpers parses credential helpers of the form
+// '[<host-pattern>=]<path>'. Relative paths are relative to the workspace,
+// which '%workspace%' can also be used to refer to. Paths without any
+// directory are looked up in the workspace first, and then in PATH.
+func newCredentialHelpers(specs []string, workspacePath string) (*credentialHelpers, error) {
+	c := &credentialHelpers{
+		hosts:         make(map[string]string),
+		workspacePath: workspacePath,
+		now:           time.Now,
+		credentials:   make(map[string]cachedCredentials),
+	}
+
+	for _, spec := range specs {
+		pattern, helper, scoped := strings.Cut(spec, "=")
+		if !scoped {
+			pattern, helper = "", spec
+		}
+		if err := validateHostPattern(pattern); scoped && err != nil {
+			return nil, fmt.Errorf("invalid credential helper %s: %w", spec, err)
+		}
+
+		path, err := resolveCredentialHelper(helper, workspacePath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credential helper %s: %w", spec, err)
+		}
+
+		if pattern == "" {
+			c.defaultHelper = path
+		} else {
+			c.hosts[strings.ToLower(pattern)] = path
+		}
+	}
+
+	return c, nil
+}
+
+func validateHostPattern(pattern string) error {
+	host := strings.TrimPrefix(pattern, "*.")
+	if host == "" || strings.ContainsAny(host, "*/:") {
+		return fmt.Errorf("expected a host or a pattern like *.example.com, not %q", pattern)
+	}
+	return nil
+}
+
+func resolveCredentialHelper(helper, workspacePath string) (string, error) {
+	helper = strings.Replace(helper, "%workspace%", workspacePath, 1)
+	switch {
+	case helper == "":
+		return "", fmt.Errorf("empty path")
+	case filepath.IsAbs(helper):
+		return helper, nil
+	case strings.ContainsRune(helper, filepath.Separator):
+		return filepath.Join(workspacePath, helper), nil
+	}
+
+	if path := filepath.Join(workspacePath, helper); fileExists(path) {
+		return path, nil
+	}
+	return exec.LookPath(helper)
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
 }
 
-func getAuthorization(credentialHelper, workspacePath string) ([]string, error) {
-	if credentialHelper == "" {
+// helperFor finds the helper to use for the given host, if any.
+func (c *credentialHelpers) helperFor(host string) string {
+	host = strings.ToLower(host)
+	if helper, ok := c.hosts[host]; ok {
+		return helper
+	}
+
+	// the longest matching wildcard is the most specific;
+	// '*.example.com' matches both example.com and its subdomains
+	var helper, match string
+	for pattern, h := range c.hosts {
+		domain, ok := strings.CutPrefix(pattern, "*.")
+		if !ok || len(domain) <= len(match) {
+			continue
+		}
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			helper, match = h, domain
+		}
+	}
+	if helper != "" {
+		return helper
+	}
+
+	return c.defaultHelper
+}
+
+func (c *credentialHelpers) Headers(ctx context.Context, uri string) (map[string][]string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse uri %s: %w", uri, err)
+	}
+
+	helper := c.helperFor(u.Hostname())
+	if helper == "" {
 		return nil, nil
 	}
 
-	cmd := exec.Cmd{Path: credentialHelper, Dir: workspacePath}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cached, ok := c.credentials[uri]; ok && c.now().Before(cached.expires) {
+		return cached.headers, nil
+	}
 
-	headers, err := cmd.Output()
+	resp, err := c.run(ctx, helper, uri)
 	if err != nil {
 		return nil, err
 	}
 
-	credentials := &Credentials{}
-	if err := json.Unmarshal(headers, &credentials); err != nil {
-		return nil, fmt.Errorf("invalid headers %s: %w", headers, err)
+	expires := c.now().Add(credentialHelperCacheDuration)
+	if resp.Expires != "" {
+		t, err := time.Parse(time.RFC3339, resp.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("credential helper %s returned invalid expires %q: %w", helper, resp.Expires, err)
+		}
+		if t.Before(expires) {
+			expires = t
+		}
+	}
+
+	c.credentials[uri] = cachedCredentials{headers: resp.Headers, expires: expires}
+	return resp.Headers, nil
+}
+
+// run runs the helper to get credentials for the uri.
+func (c *credentialHelpers) run(ctx context.Context, helper, uri string) (*credentialHelperResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, credentialHelperTimeout)
+	defer cancel()
+
+	req, err := json.Marshal(credentialHelperRequest{URI: uri})
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, helper, "get")
+	cmd.Dir = c.workspacePath
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("credential helper %s failed for %s: %w: %s", helper, uri, err, strings.TrimSpace(stderr.String()))
 	}
 
-	if len(credentials.Headers.Authorization) == 0 {
-		return nil, fmt.Errorf("empty authorization header")
+	resp := &credentialHelperResponse{}
+	if err := json.Unmarshal(stdout.Bytes(), resp); err != nil {
+		return nil, fmt.Errorf("credential helper %s returned an invalid response for %s: %w", helper, uri, err)
 	}
 
-	return credentials.Headers.Authorization, nil
+	return resp, nil
 }
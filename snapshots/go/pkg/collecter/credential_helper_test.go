@@ -0,0 +1,177 @@
+package collecter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeHelper writes a credential helper script to dir, which responds with
+// the given output and logs its arguments and requests to <name>.log.
+func writeHelper(t *testing.T, dir, name, output string) string {
+	t.Helper()
+
+	script := `#!/bin/sh
+echo "$@ $(cat)" >> "$0.log"
+cat <<'EOF'
+` + output + `
+EOF
+`
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(script), 0o755))
+	return path
+}
+
+// helperCalls returns the logged invocations of a helper written with
+// writeHelper.
+func helperCalls(t *testing.T, path string) []string {
+	t.Helper()
+
+	b, err := os.ReadFile(path + ".log")
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+func TestCredentialHelpers_Headers(t *testing.T) {
+	dir := t.TempDir()
+	helper := writeHelper(t, dir, "helper.sh", `{"headers": {"Authorization": ["Bearer token"], "X-Other": ["a", "b"]}}`)
+
+	c, err := newCredentialHelpers([]string{helper}, dir)
+	require.NoError(t, err)
+
+	headers, err := c.Headers(t.Context(), "https://cache.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"Authorization": {"Bearer token"},
+		"X-Other":       {"a", "b"},
+	}, headers)
+
+	// the helper is run with the get command, and the uri on stdin
+	assert.Equal(t, []string{`get {"uri":"https://cache.example.com"}`}, helperCalls(t, helper))
+}
+
+func TestCredentialHelpers_cache(t *testing.T) {
+	dir := t.TempDir()
+	expires := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	helper := writeHelper(t, dir, "helper.sh", `{"headers": {"Authorization": ["Bearer token"]}, "expires": "`+expires.Format(time.RFC3339)+`"}`)
+
+	c, err := newCredentialHelpers([]string{helper}, dir)
+	require.NoError(t, err)
+
+	now := expires.Add(-time.Minute)
+	c.now = func() time.Time { return now }
+
+	for range 3 {
+		_, err := c.Headers(t.Context(), "https://cache.example.com")
+		require.NoError(t, err)
+	}
+	assert.Len(t, helperCalls(t, helper), 1, "cached until expires")
+
+	_, err = c.Headers(t.Context(), "https://other.example.com")
+	require.NoError(t, err)
+	assert.Len(t, helperCalls(t, helper), 2, "cached per uri")
+
+	now = expires
+	_, err = c.Headers(t.Context(), "https://cache.example.com")
+	require.NoError(t, err)
+	assert.Len(t, helperCalls(t, helper), 3, "run again when expired")
+}
+
+func TestCredentialHelpers_scopes(t *testing.T) {
+	dir := t.TempDir()
+	writeHelper(t, dir, "default.sh", `{"headers": {"Helper": ["default"]}}`)
+	writeHelper(t, dir, "exact.sh", `{"headers": {"Helper": ["exact"]}}`)
+	writeHelper(t, dir, "wildcard.sh", `{"headers": {"Helper": ["wildcard"]}}`)
+	writeHelper(t, dir, "sub.sh", `{"headers": {"Helper": ["sub"]}}`)
+	writeHelper(t, dir, "replaced.sh", `{"headers": {"Helper": ["replaced"]}}`)
+
+	c, err := newCredentialHelpers([]string{
+		"default.sh",
+		"%workspace%/replaced.sh",
+		"cache.example.com=replaced.sh",
+		"cache.example.com=exact.sh",
+		"*.example.com=wildcard.sh",
+		"*.sub.example.com=./sub.sh",
+	}, dir)
+	require.NoError(t, err)
+
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{uri: "https://cache.example.com", want: "exact"},
+		{uri: "grpcs://CACHE.example.com:443", want: "exact"},
+		{uri: "https://other.example.com", want: "wildcard"},
+		{uri: "https://example.com", want: "wildcard"},
+		{uri: "https://a.sub.example.com", want: "sub"},
+		{uri: "https://notexample.com", want: "replaced"},
+		{uri: "https://other.org", want: "replaced"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			headers, err := c.Headers(t.Context(), tt.uri)
+			require.NoError(t, err)
+			assert.Equal(t, []string{tt.want}, headers["Helper"])
+		})
+	}
+
+	t.Run("NoDefault", func(t *testing.T) {
+		c, err := newCredentialHelpers([]string{"cache.example.com=exact.sh"}, dir)
+		require.NoError(t, err)
+
+		headers, err := c.Headers(t.Context(), "https://other.example.com")
+		require.NoError(t, err)
+		assert.Empty(t, headers)
+	})
+}
+
+func TestCredentialHelpers_errors(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("InvalidPattern", func(t *testing.T) {
+		_, err := newCredentialHelpers([]string{"https://host=helper.sh"}, dir)
+		assert.ErrorContains(t, err, "invalid credential helper")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := newCredentialHelpers([]string{"no-such-credential-helper"}, dir)
+		assert.ErrorContains(t, err, "invalid credential helper")
+	})
+
+	t.Run("HelperFails", func(t *testing.T) {
+		path := filepath.Join(dir, "fails.sh")
+		require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\necho 'no credentials' >&2\nexit 1\n"), 0o755))
+
+		c, err := newCredentialHelpers([]string{path}, dir)
+		require.NoError(t, err)
+		_, err = c.Headers(t.Context(), "https://cache.example.com")
+		assert.ErrorContains(t, err, "no credentials")
+	})
+
+	t.Run("InvalidResponse", func(t *testing.T) {
+		helper := writeHelper(t, dir, "invalid.sh", `Authorization: Bearer token`)
+
+		c, err := newCredentialHelpers([]string{helper}, dir)
+		require.NoError(t, err)
+		_, err = c.Headers(t.Context(), "https://cache.example.com")
+		assert.ErrorContains(t, err, "invalid response")
+	})
+
+	t.Run("InvalidExpires", func(t *testing.T) {
+		helper := writeHelper(t, dir, "expires.sh", `{"headers": {}, "expires": "tomorrow"}`)
+
+		c, err := newCredentialHelpers([]string{helper}, dir)
+		require.NoError(t, err)
+		_, err = c.Headers(t.Context(), "https://cache.example.com")
+		assert.ErrorContains(t, err, "invalid expires")
+	})
+}
@@ -0,0 +1,112 @@
+package collecter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+)
+
+// collectMetadata describes how a snapshot was collected, from start until
+// now. query is the query expression which was built, if Bazel was run by
+// Collect.
+func collectMetadata(ctx context.Context, args *CollectArgs, build *buildResult, query string, start time.Time) *models.Metadata {
+	metadata := &models.Metadata{
+		BazelVersion: build.bazelVersion,
+		Query:        query,
+		ToolVersion:  toolVersion(),
+		CollectedAt:  time.Now().UTC(),
+		Duration:     time.Since(start).Round(time.Millisecond).String(),
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		metadata.Host = host
+	}
+
+	if !args.SkipGitMetadata {
+		git, err := gitMetadata(ctx, args.BazelWorkspacePath)
+		if err != nil {
+			log.Printf("warning: no git metadata: %v", err)
+		}
+		metadata.Git = git
+	}
+
+	return metadata
+}
+
+// gitMetadata returns the state of the git repository in dir.
+func gitMetadata(ctx context.Context, dir string) (*models.GitMetadata, error) {
+	commit, err := git(ctx, dir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	// fails if HEAD is detached
+	branch, _ := git(ctx, dir, "symbolic-ref", "--short", "-q", "HEAD")
+
+	status, err := git(ctx, dir, "status", "--porcelain")
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.GitMetadata{
+		Commit: commit,
+		Branch: branch,
+		Dirty:  status != "",
+	}, nil
+}
+
+// git runs a git command in dir, and returns its trimmed output.
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() > 0 {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// toolVersion returns the version of snapshots itself: the module version
+// if installed with 'go install', or else the revision it was built from,
+// if known.
+func toolVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+
+	var revision string
+	var modified bool
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision != "" && modified {
+		revision += "-dirty"
+	}
+	return revision
+}
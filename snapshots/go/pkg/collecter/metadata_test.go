@@ -0,0 +1,64 @@
+package collecter
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitMetadata(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	dir := t.TempDir()
+	// don't find a repository the temporary directory is in
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(dir))
+
+	run := func(args ...string) string {
+		t.Helper()
+		out, err := git(t.Context(), dir, args...)
+		require.NoError(t, err)
+		return out
+	}
+
+	_, err := gitMetadata(t.Context(), dir)
+	assert.ErrorContains(t, err, "git rev-parse")
+
+	run("init", "-q", "-b", "main")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "BUILD"), nil, 0o644))
+	run("add", "BUILD")
+	run("-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial")
+	commit := run("rev-parse", "HEAD")
+
+	t.Run("Clean", func(t *testing.T) {
+		got, err := gitMetadata(t.Context(), dir)
+		require.NoError(t, err)
+		assert.Equal(t, commit, got.Commit)
+		assert.Equal(t, "main", got.Branch)
+		assert.False(t, got.Dirty)
+	})
+
+	t.Run("Dirty", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "BUILD"), []byte("# changed"), 0o644))
+		t.Cleanup(func() { run("checkout", "-q", "BUILD") })
+
+		got, err := gitMetadata(t.Context(), dir)
+		require.NoError(t, err)
+		assert.True(t, got.Dirty)
+	})
+
+	t.Run("Detached", func(t *testing.T) {
+		run("checkout", "-q", "--detach")
+		t.Cleanup(func() { run("checkout", "-q", "main") })
+
+		got, err := gitMetadata(t.Context(), dir)
+		require.NoError(t, err)
+		assert.Equal(t, commit, got.Commit)
+		assert.Empty(t, got.Branch)
+	})
+}
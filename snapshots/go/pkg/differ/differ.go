@@ -5,12 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
+	"slices"
 	"sort"
 	"strings"
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/olekukonko/tablewriter/tw"
 
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/cache"
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/collecter"
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
 )
@@ -24,17 +27,55 @@ func NewDiffer() *differ {
 type DiffArgs struct {
 	BazelCacheGrpcs        bool
 	BazelCacheGrpcMetadata []string
+	BazelCacheHTTPURL      string
+	BazelCacheHTTPOptions  cache.HTTPClientOptions
 	BazelExpression        string
 	BazelPath              string
 	BazelRcPath            string
 	BazelWorkspacePath     string
 	BazelWriteStderr       bool
 	BuildEventsPath        string
-	CredentialHelper       string
+	BuildEventsBinaryPath  string
+	CredentialHelpers      []string
+	Jobs                   int
 	OutPath                string
 	NoPrint                bool
+	KeepGoing              bool
+	BaseSnapshot           *models.Snapshot
 	FromSnapshot           *models.Snapshot
 	ToSnapshot             *models.Snapshot
+
+	// MetadataChanges is how labels are reported whose run labels or tags
+	// changed, but not their digest. Defaults to MetadataChangesReport.
+	MetadataChanges MetadataChanges
+
+	// Filter selects the changes to return, if set.
+	Filter *Filter
+}
+
+// MetadataChanges is how changes to only the run labels or tags of a
+// tracker are reported.
+type MetadataChanges string
+
+const (
+	// MetadataChangesReport reports them as metadata-changed.
+	MetadataChangesReport MetadataChanges = "report"
+	// MetadataChangesChanged reports them as changed.
+	MetadataChangesChanged MetadataChanges = "changed"
+	// MetadataChangesIgnore reports them as unchanged.
+	MetadataChangesIgnore MetadataChanges = "ignore"
+)
+
+// ParseMetadataChanges parses how to report metadata changes, where "" is
+// MetadataChangesReport.
+func ParseMetadataChanges(s string) (MetadataChanges, error) {
+	switch m := MetadataChanges(s); m {
+	case "":
+		return MetadataChangesReport, nil
+	case MetadataChangesReport, MetadataChangesChanged, MetadataChangesIgnore:
+		return m, nil
+	}
+	return "", fmt.Errorf(`unknown metadata changes %q, must be "report", "changed" or "ignore"`, s)
 }
 
 func (*differ) Diff(args *DiffArgs) ([]models.TrackerChange, error) {
@@ -43,15 +84,22 @@ func (*differ) Diff(args *DiffArgs) ([]models.TrackerChange, error) {
 		collectArgs := collecter.CollectArgs{
 			BazelCacheGrpcs:        args.BazelCacheGrpcs,
 			BazelCacheGrpcMetadata: args.BazelCacheGrpcMetadata,
+			BazelCacheHTTPURL:      args.BazelCacheHTTPURL,
+			BazelCacheHTTPOptions:  args.BazelCacheHTTPOptions,
 			BazelExpression:        args.BazelExpression,
 			BazelPath:              args.BazelPath,
 			BazelRcPath:            args.BazelRcPath,
 			BazelWorkspacePath:     args.BazelWorkspacePath,
 			BazelWriteStderr:       args.BazelWriteStderr,
 			BazelBuildEventsPath:   args.BuildEventsPath,
-			CredentialHelper:       args.CredentialHelper,
+			BuildEventsBinaryPath:  args.BuildEventsBinaryPath,
+			CredentialHelpers:      args.CredentialHelpers,
+			Jobs:                   args.Jobs,
 			OutPath:                args.OutPath,
 			NoPrint:                args.NoPrint,
+			KeepGoing:              args.KeepGoing,
+			BaseSnapshot:           args.BaseSnapshot,
+			KnownTrackers:          slices.Collect(maps.Keys(args.FromSnapshot.Labels)),
 		}
 		snapshot, err := collecter.NewCollecter().Collect(&collectArgs)
 		if err != nil {
@@ -78,39 +126,65 @@ func (*differ) Diff(args *DiffArgs) ([]models.TrackerChange, error) {
 
 		change := models.TrackerChange{
 			Label: label,
+			From:  fromTracker,
+			To:    toTracker,
 		}
 
 		if toTracker != nil {
 			change.Tracker = *toTracker
 		}
 
-		if fromTracker == nil {
+		if toTracker != nil && toTracker.Unknown {
+			change.ChangeType = models.Unknown
+		} else if fromTracker == nil {
 			change.ChangeType = models.Added
 		} else if toTracker == nil {
 			change.ChangeType = models.Removed
-		} else if fromTracker.Digest != toTracker.Digest {
+		} else if fromTracker.Digest != toTracker.Digest || fromTracker.Unknown {
 			change.ChangeType = models.Changed
+		} else if !sameMetadata(fromTracker, toTracker) && args.MetadataChanges != MetadataChangesIgnore {
+			change.ChangeType = models.MetadataChanged
+			if args.MetadataChanges == MetadataChangesChanged {
+				change.ChangeType = models.Changed
+			}
 		} else {
 			change.ChangeType = models.Unchanged
 		}
 
+		if args.Filter != nil && !args.Filter.Match(change) {
+			continue
+		}
+
 		changes = append(changes, change)
 	}
 
 	return changes, nil
 }
 
-// diffOutputLabel writes added or changed labels, one per line.
+// sameMetadata returns whether two trackers have the same run labels and
+// tags, in any order.
+func sameMetadata(a, b *models.Tracker) bool {
+	return slices.Equal(sorted(a.Run), sorted(b.Run)) && slices.Equal(sorted(a.Tags), sorted(b.Tags))
+}
+
+// sorted returns a sorted, deduplicated copy of s.
+func sorted(s []string) []string {
+	return slices.Compact(slices.Sorted(slices.Values(s)))
+}
+
+// diffOutputLabel writes added, changed, metadata-changed or unknown labels,
+// one per line. Unknown labels are included, as they may have changed.
 func (*differ) DiffOutputLabel(dest io.Writer, changes []models.TrackerChange) error {
 	for _, change := range changes {
-		if change.ChangeType == models.Added || change.ChangeType == models.Changed {
+		switch change.ChangeType {
+		case models.Added, models.Changed, models.MetadataChanged, models.Unknown:
 			fmt.Fprintf(dest, "%s\n", change.Label)
 		}
 	}
 	return nil
 }
 
-// diffOutputJSON writes added, changed or removed TrackerChanges as a JSON list.
+// diffOutputJSON writes added, changed, removed or unknown TrackerChanges as a JSON list.
 func (*differ) DiffOutputJSON(dest io.Writer, changes []models.TrackerChange) error {
 	changedOrAdded := make([]models.TrackerChange, 0, len(changes))
 	for _, change := range changes {
@@ -128,7 +202,7 @@ func (*differ) DiffOutputJSON(dest io.Writer, changes []models.TrackerChange) er
 	return err
 }
 
-// diffOutputPretty writes a human-readable table of added, changed or removed trackers.
+// diffOutputPretty writes a human-readable table of added, changed, removed or unknown trackers.
 func (*differ) DiffOutputPretty(dest io.Writer, changes []models.TrackerChange) error {
 	table := tablewriter.NewWriter(dest)
 	tablewriter.WithRowMergeMode(tw.MergeHorizontal)
@@ -141,33 +215,40 @@ func (*differ) DiffOutputPretty(dest io.Writer, changes []models.TrackerChange)
 	})
 
 	// a somewhat arbitrary sorting algorithm attempting to make things pretty
+	tags := func(change models.TrackerChange) []string {
+		return lastKnown(change).Tags
+	}
 	for _, change := range changes {
-		sort.Strings(change.Tags)
+		sort.Strings(tags(change))
 	}
 	sort.Slice(changes, func(i, j int) bool {
 		if changes[i].ChangeType.String() != changes[j].ChangeType.String() {
 			return changes[i].ChangeType.String() < changes[j].ChangeType.String()
 		}
-		if len(changes[i].Tags) != len(changes[j].Tags) {
-			return len(changes[i].Tags) < len(changes[j].Tags)
+		tagsI, tagsJ := tags(changes[i]), tags(changes[j])
+		if len(tagsI) != len(tagsJ) {
+			return len(tagsI) < len(tagsJ)
 		}
-		for idx := range changes[i].Tags {
-			if len(changes[j].Tags) >= idx+1 {
-				if changes[i].Tags[idx] != changes[j].Tags[idx] {
-					return changes[i].Tags[idx] < changes[j].Tags[idx]
-				}
+		for idx := range tagsI {
+			if tagsI[idx] != tagsJ[idx] {
+				return tagsI[idx] < tagsJ[idx]
 			}
 		}
 		return changes[i].Label < changes[j].Label
 	})
 
-	table.Header([]string{"Change", "Tags", "Label"})
+	table.Header([]string{"Change", "Tags", "Run", "Label"})
 	for _, change := range changes {
-		if change.ChangeType == models.Added || change.ChangeType == models.Changed || change.ChangeType == models.Removed {
+		if change.ChangeType != models.Unchanged {
+			label := change.Label
+			if change.CarriedForward {
+				label += " (carried forward)"
+			}
 			table.Append([]string{
 				change.ChangeType.String(),
-				strings.Join(change.Tags, "\n"),
-				change.Label,
+				describeList(change, func(t *models.Tracker) []string { return t.Tags }),
+				describeList(change, func(t *models.Tracker) []string { return t.Run }),
+				label,
 			})
 		}
 	}
@@ -175,3 +256,40 @@ func (*differ) DiffOutputPretty(dest io.Writer, changes []models.TrackerChange)
 	table.Render()
 	return nil
 }
+
+// lastKnown returns the tracker of the label in the snapshot diffed to, or
+// if it was removed, in the snapshot diffed from.
+func lastKnown(change models.TrackerChange) *models.Tracker {
+	if change.To != nil {
+		return change.To
+	}
+	if change.From != nil {
+		return change.From
+	}
+	return &change.Tracker
+}
+
+// describeList describes the tags or run labels of a change, one per line.
+// If they were changed, the ones which were removed and added are marked
+// with - and +.
+func describeList(change models.TrackerChange, list func(*models.Tracker) []string) string {
+	if change.From == nil || change.To == nil {
+		return strings.Join(list(lastKnown(change)), "\n")
+	}
+
+	from, to := list(change.From), list(change.To)
+	var lines []string
+	for _, item := range to {
+		if slices.Contains(from, item) {
+			lines = append(lines, item)
+		} else {
+			lines = append(lines, "+ "+item)
+		}
+	}
+	for _, item := range from {
+		if !slices.Contains(to, item) {
+			lines = append(lines, "- "+item)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
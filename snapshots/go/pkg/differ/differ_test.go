@@ -0,0 +1,138 @@
+package differ
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+)
+
+func TestDiff(t *testing.T) {
+	from := &models.Snapshot{Labels: map[string]*models.Tracker{
+		"//:kept":    {Digest: "a", Run: []string{"//:deploy_kept"}},
+		"//:changed": {Digest: "b", Tags: []string{"old"}},
+		"//:removed": {Digest: "c", Run: []string{"//:teardown"}, Tags: []string{"svc"}},
+	}}
+	to := &models.Snapshot{Labels: map[string]*models.Tracker{
+		"//:kept":    {Digest: "a", Run: []string{"//:deploy_kept"}},
+		"//:changed": {Digest: "d", Tags: []string{"new"}},
+		"//:added":   {Digest: "e"},
+	}}
+
+	d := NewDiffer()
+	changes, err := d.Diff(&DiffArgs{FromSnapshot: from, ToSnapshot: to})
+	require.NoError(t, err)
+
+	byLabel := make(map[string]models.TrackerChange)
+	for _, change := range changes {
+		byLabel[change.Label] = change
+	}
+	require.Len(t, byLabel, 4)
+
+	assert.Equal(t, models.Unchanged, byLabel["//:kept"].ChangeType)
+	assert.Equal(t, models.Added, byLabel["//:added"].ChangeType)
+	assert.Nil(t, byLabel["//:added"].From)
+
+	changed := byLabel["//:changed"]
+	assert.Equal(t, models.Changed, changed.ChangeType)
+	assert.Equal(t, "d", changed.Digest)
+	assert.Equal(t, "b", changed.From.Digest)
+	assert.Equal(t, "d", changed.To.Digest)
+
+	// removed labels carry their last known tracker in from
+	removed := byLabel["//:removed"]
+	assert.Equal(t, models.Removed, removed.ChangeType)
+	assert.Nil(t, removed.To)
+	require.NotNil(t, removed.From)
+	assert.Equal(t, "c", removed.From.Digest)
+	assert.Equal(t, []string{"//:teardown"}, removed.From.Run)
+
+	var out bytes.Buffer
+	require.NoError(t, d.DiffOutputJSON(&out, changes))
+	var decoded []map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Len(t, decoded, 3)
+	for _, change := range decoded {
+		if change["label"] == "//:removed" {
+			assert.Equal(t, map[string]any{
+				"digest": "c",
+				"run":    []any{"//:teardown"},
+				"tags":   []any{"svc"},
+			}, change["from"])
+			assert.NotContains(t, change, "to")
+		}
+	}
+
+	out.Reset()
+	require.NoError(t, d.DiffOutputPretty(&out, changes))
+	assert.Contains(t, out.String(), "//:teardown")
+	assert.Contains(t, out.String(), "+ new")
+	assert.Contains(t, out.String(), "- old")
+}
+
+func TestDiffMetadataChanges(t *testing.T) {
+	from := &models.Snapshot{Labels: map[string]*models.Tracker{
+		"//:run":  {Digest: "a", Run: []string{"//:deploy"}},
+		"//:tags": {Digest: "b", Tags: []string{"k8s", "eu"}},
+		"//:same": {Digest: "c", Tags: []string{"k8s", "eu"}},
+	}}
+	to := &models.Snapshot{Labels: map[string]*models.Tracker{
+		"//:run":  {Digest: "a", Run: []string{"//:deploy_v2"}},
+		"//:tags": {Digest: "b", Tags: []string{"k8s"}},
+		"//:same": {Digest: "c", Tags: []string{"eu", "k8s"}},
+	}}
+
+	for _, tt := range []struct {
+		mode MetadataChanges
+		want models.ChangeType
+	}{
+		{mode: "", want: models.MetadataChanged},
+		{mode: MetadataChangesReport, want: models.MetadataChanged},
+		{mode: MetadataChangesChanged, want: models.Changed},
+		{mode: MetadataChangesIgnore, want: models.Unchanged},
+	} {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			d := NewDiffer()
+			changes, err := d.Diff(&DiffArgs{FromSnapshot: from, ToSnapshot: to, MetadataChanges: tt.mode})
+			require.NoError(t, err)
+
+			got := make(map[string]models.ChangeType)
+			for _, change := range changes {
+				got[change.Label] = change.ChangeType
+			}
+			assert.Equal(t, map[string]models.ChangeType{
+				"//:run":  tt.want,
+				"//:tags": tt.want,
+				"//:same": models.Unchanged,
+			}, got)
+
+			var out bytes.Buffer
+			require.NoError(t, d.DiffOutputLabel(&out, changes))
+			if tt.want == models.Unchanged {
+				assert.Empty(t, out.String())
+			} else {
+				assert.ElementsMatch(t, []string{"//:run", "//:tags"}, strings.Fields(out.String()))
+			}
+		})
+	}
+}
+
+func TestDiffOutputLabel(t *testing.T) {
+	changes := []models.TrackerChange{
+		{Label: "//:added", ChangeType: models.Added},
+		{Label: "//:changed", ChangeType: models.Changed},
+		{Label: "//:metadata", ChangeType: models.MetadataChanged},
+		{Label: "//:unknown", ChangeType: models.Unknown},
+		{Label: "//:removed", ChangeType: models.Removed},
+		{Label: "//:unchanged", ChangeType: models.Unchanged},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, NewDiffer().DiffOutputLabel(&out, changes))
+	assert.Equal(t, "//:added\n//:changed\n//:metadata\n//:unknown\n", out.String())
+}
@@ -0,0 +1,97 @@
+package differ
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+)
+
+// Filter selects the changes to report, by the tags and labels of their
+// trackers. The tags of a change are those of its tracker in the snapshot
+// diffed to, or the last known ones if it was removed.
+type Filter struct {
+	// IncludeTags keeps only changes matching any of these tag
+	// expressions, if any are given.
+	IncludeTags []TagExpression
+
+	// ExcludeTags drops changes matching any of these tag expressions.
+	ExcludeTags []TagExpression
+
+	// LabelPatterns keeps only changes whose labels match any of these
+	// patterns, if any are given.
+	LabelPatterns []*regexp.Regexp
+}
+
+// TagExpression is a set of tags which all have to be present, written as
+// tags joined by "+", like "k8s+eu".
+type TagExpression []string
+
+// ParseTagExpression parses a tag expression, like "k8s+eu".
+func ParseTagExpression(s string) (TagExpression, error) {
+	tags := strings.Split(s, "+")
+	if slices.Contains(tags, "") {
+		return nil, fmt.Errorf("invalid tag expression %q", s)
+	}
+	return tags, nil
+}
+
+func (e TagExpression) match(tags []string) bool {
+	for _, tag := range e {
+		if !slices.Contains(tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewFilter creates a filter from tag expressions and regular expressions
+// matching labels (see Filter).
+func NewFilter(includeTags, excludeTags, labelPatterns []string) (*Filter, error) {
+	f := &Filter{}
+	for _, s := range includeTags {
+		e, err := ParseTagExpression(s)
+		if err != nil {
+			return nil, err
+		}
+		f.IncludeTags = append(f.IncludeTags, e)
+	}
+	for _, s := range excludeTags {
+		e, err := ParseTagExpression(s)
+		if err != nil {
+			return nil, err
+		}
+		f.ExcludeTags = append(f.ExcludeTags, e)
+	}
+	for _, s := range labelPatterns {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label pattern %q: %w", s, err)
+		}
+		f.LabelPatterns = append(f.LabelPatterns, re)
+	}
+	return f, nil
+}
+
+// Match returns whether a change is selected by the filter.
+func (f *Filter) Match(change models.TrackerChange) bool {
+	tags := lastKnown(change).Tags
+	matchTags := func(e TagExpression) bool {
+		return e.match(tags)
+	}
+
+	if len(f.IncludeTags) > 0 && !slices.ContainsFunc(f.IncludeTags, matchTags) {
+		return false
+	}
+	if slices.ContainsFunc(f.ExcludeTags, matchTags) {
+		return false
+	}
+	if len(f.LabelPatterns) > 0 && !slices.ContainsFunc(f.LabelPatterns, func(re *regexp.Regexp) bool {
+		return re.MatchString(change.Label)
+	}) {
+		return false
+	}
+	return true
+}
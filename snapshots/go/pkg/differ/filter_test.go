@@ -0,0 +1,86 @@
+package differ
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+)
+
+func TestFilter(t *testing.T) {
+	from := &models.Snapshot{Labels: map[string]*models.Tracker{
+		"//k8s/eu:deploy":       {Digest: "a", Tags: []string{"k8s", "eu"}},
+		"//k8s/us:deploy":       {Digest: "a", Tags: []string{"k8s", "us"}},
+		"//terraform:apply":     {Digest: "a", Tags: []string{"terraform"}},
+		"//notify:slack":        {Digest: "a", Tags: []string{"notify-slack"}},
+		"//k8s/legacy:teardown": {Digest: "a", Tags: []string{"k8s", "eu"}},
+	}}
+	to := &models.Snapshot{Labels: map[string]*models.Tracker{
+		"//k8s/eu:deploy":   {Digest: "b", Tags: []string{"k8s", "eu"}},
+		"//k8s/us:deploy":   {Digest: "b", Tags: []string{"k8s", "us"}},
+		"//terraform:apply": {Digest: "b", Tags: []string{"terraform"}},
+		"//notify:slack":    {Digest: "b", Tags: []string{"notify-slack"}},
+	}}
+
+	for _, tt := range []struct {
+		name                                    string
+		includeTags, excludeTags, labelPatterns []string
+		want                                    []string
+	}{
+		{
+			name: "None",
+			want: []string{"//k8s/eu:deploy", "//k8s/us:deploy", "//terraform:apply", "//notify:slack", "//k8s/legacy:teardown"},
+		},
+		{
+			name:        "Include",
+			includeTags: []string{"k8s"},
+			want:        []string{"//k8s/eu:deploy", "//k8s/us:deploy", "//k8s/legacy:teardown"},
+		},
+		{
+			name:        "IncludeAny",
+			includeTags: []string{"terraform", "notify-slack"},
+			want:        []string{"//terraform:apply", "//notify:slack"},
+		},
+		{
+			name:        "IncludeAll",
+			includeTags: []string{"k8s+eu"},
+			want:        []string{"//k8s/eu:deploy", "//k8s/legacy:teardown"},
+		},
+		{
+			name:        "Exclude",
+			includeTags: []string{"k8s"},
+			excludeTags: []string{"us"},
+			want:        []string{"//k8s/eu:deploy", "//k8s/legacy:teardown"},
+		},
+		{
+			name:          "LabelPattern",
+			includeTags:   []string{"k8s"},
+			labelPatterns: []string{`:deploy$`},
+			want:          []string{"//k8s/eu:deploy", "//k8s/us:deploy"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewFilter(tt.includeTags, tt.excludeTags, tt.labelPatterns)
+			require.NoError(t, err)
+
+			changes, err := NewDiffer().Diff(&DiffArgs{FromSnapshot: from, ToSnapshot: to, Filter: filter})
+			require.NoError(t, err)
+
+			var got []string
+			for _, change := range changes {
+				got = append(got, change.Label)
+			}
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewFilterInvalid(t *testing.T) {
+	_, err := NewFilter([]string{"k8s+"}, nil, nil)
+	assert.ErrorContains(t, err, "invalid tag expression")
+
+	_, err = NewFilter(nil, nil, []string{"("})
+	assert.ErrorContains(t, err, "invalid label pattern")
+}
@@ -0,0 +1,117 @@
+package differ
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+)
+
+// markdownGroups are the change types in the order they're listed in
+// Markdown, and whether their group is expanded.
+var markdownGroups = []struct {
+	changeType models.ChangeType
+	title      string
+	open       bool
+}{
+	{models.Added, "Added", true},
+	{models.Changed, "Changed", true},
+	{models.MetadataChanged, "Metadata changed", true},
+	{models.Removed, "Removed", true},
+	{models.Unknown, "Unknown", false},
+}
+
+// DiffOutputMarkdown writes a Markdown summary of added, changed, removed or
+// unknown trackers, for pull request comments. The changes are grouped by
+// type, in collapsible sections. If maxRows is positive, at most that many
+// trackers are listed, and the rest are only counted.
+func (*differ) DiffOutputMarkdown(dest io.Writer, changes []models.TrackerChange, maxRows int) error {
+	var b strings.Builder
+
+	groups := make(map[models.ChangeType][]models.TrackerChange)
+	var counts []string
+	for _, group := range markdownGroups {
+		for _, change := range changes {
+			if change.ChangeType == group.changeType {
+				groups[group.changeType] = append(groups[group.changeType], change)
+			}
+		}
+		if n := len(groups[group.changeType]); n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, strings.ToLower(group.title)))
+		}
+	}
+
+	if len(counts) == 0 {
+		_, err := io.WriteString(dest, "No changes.\n")
+		return err
+	}
+	fmt.Fprintf(&b, "**Changes:** %s\n", strings.Join(counts, ", "))
+
+	remaining := maxRows
+	for _, group := range markdownGroups {
+		groupChanges := groups[group.changeType]
+		if len(groupChanges) == 0 {
+			continue
+		}
+		slices.SortFunc(groupChanges, func(a, b models.TrackerChange) int {
+			return strings.Compare(a.Label, b.Label)
+		})
+
+		shown := groupChanges
+		if maxRows > 0 {
+			shown = groupChanges[:min(len(groupChanges), remaining)]
+			remaining -= len(shown)
+		}
+
+		open := ""
+		if group.open {
+			open = " open"
+		}
+		fmt.Fprintf(&b, "\n<details%s>\n<summary>%s (%d)</summary>\n\n", open, group.title, len(groupChanges))
+
+		if len(shown) > 0 {
+			b.WriteString("| Label | Tags | Run |\n| --- | --- | --- |\n")
+			for _, change := range shown {
+				label := markdownCode(change.Label)
+				if change.CarriedForward {
+					label += " (carried forward)"
+				}
+				fmt.Fprintf(&b, "| %s | %s | %s |\n",
+					label,
+					markdownList(describeList(change, func(t *models.Tracker) []string { return t.Tags })),
+					markdownList(describeList(change, func(t *models.Tracker) []string { return t.Run })),
+				)
+			}
+		}
+		if hidden := len(groupChanges) - len(shown); hidden > 0 {
+			if len(shown) > 0 {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "_…and %d more not shown._\n", hidden)
+		}
+
+		b.WriteString("\n</details>\n")
+	}
+
+	_, err := io.WriteString(dest, b.String())
+	return err
+}
+
+// markdownList formats the lines of describeList for a table cell.
+func markdownList(s string) string {
+	if s == "" {
+		return ""
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = markdownCode(line)
+	}
+	return strings.Join(lines, "<br>")
+}
+
+// markdownCode formats s as inline code in a table cell.
+func markdownCode(s string) string {
+	return "`" + strings.ReplaceAll(s, "|", `\|`) + "`"
+}
@@ -0,0 +1,55 @@
+package differ
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+)
+
+func TestDiffOutputMarkdown(t *testing.T) {
+	changes := []models.TrackerChange{
+		{Label: "//:b", ChangeType: models.Added, To: &models.Tracker{Digest: "b", Tags: []string{"k8s"}}},
+		{Label: "//:a", ChangeType: models.Added, To: &models.Tracker{Digest: "a", Run: []string{"//:deploy_a"}}},
+		{
+			Label:      "//:c",
+			ChangeType: models.MetadataChanged,
+			From:       &models.Tracker{Digest: "c", Run: []string{"//:old"}},
+			To:         &models.Tracker{Digest: "c", Run: []string{"//:new"}},
+		},
+		{Label: "//:d", ChangeType: models.Removed, From: &models.Tracker{Digest: "d", Run: []string{"//:teardown"}}},
+		{Label: "//:e", ChangeType: models.Unchanged, From: &models.Tracker{Digest: "e"}, To: &models.Tracker{Digest: "e"}},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, NewDiffer().DiffOutputMarkdown(&out, changes, 0))
+	assert.Equal(t, "**Changes:** 2 added, 1 metadata changed, 1 removed\n"+
+		"\n<details open>\n<summary>Added (2)</summary>\n\n"+
+		"| Label | Tags | Run |\n| --- | --- | --- |\n"+
+		"| `//:a` |  | `//:deploy_a` |\n"+
+		"| `//:b` | `k8s` |  |\n"+
+		"\n</details>\n"+
+		"\n<details open>\n<summary>Metadata changed (1)</summary>\n\n"+
+		"| Label | Tags | Run |\n| --- | --- | --- |\n"+
+		"| `//:c` |  | `+ //:new`<br>`- //:old` |\n"+
+		"\n</details>\n"+
+		"\n<details open>\n<summary>Removed (1)</summary>\n\n"+
+		"| Label | Tags | Run |\n| --- | --- | --- |\n"+
+		"| `//:d` |  | `//:teardown` |\n"+
+		"\n</details>\n", out.String())
+
+	out.Reset()
+	require.NoError(t, NewDiffer().DiffOutputMarkdown(&out, changes, 1))
+	assert.Contains(t, out.String(), "| `//:a` |")
+	assert.NotContains(t, out.String(), "| `//:b` |")
+	assert.Contains(t, out.String(), "<summary>Added (2)</summary>")
+	assert.Contains(t, out.String(), "_…and 1 more not shown._")
+	assert.Contains(t, out.String(), "<summary>Removed (1)</summary>\n\n_…and 1 more not shown._\n")
+
+	out.Reset()
+	require.NoError(t, NewDiffer().DiffOutputMarkdown(&out, changes[4:], 0))
+	assert.Equal(t, "No changes.\n", out.String())
+}
@@ -0,0 +1,72 @@
+package differ
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+)
+
+// NewRunPlan collects the run labels of changed trackers, each only once,
+// with the changes which trigger them. The run labels of added, changed or
+// metadata-changed trackers are to be run, and those of removed trackers
+// are listed separately. Trackers which are unknown are left out, as it's
+// not known whether they changed.
+func NewRunPlan(changes []models.TrackerChange) *models.RunPlan {
+	run := make(map[string][]models.RunTrigger)
+	removed := make(map[string][]models.RunTrigger)
+	for _, change := range changes {
+		var targets map[string][]models.RunTrigger
+		switch change.ChangeType {
+		case models.Added, models.Changed, models.MetadataChanged:
+			targets = run
+		case models.Removed:
+			targets = removed
+		default:
+			continue
+		}
+
+		for _, label := range lastKnown(change).Run {
+			targets[label] = append(targets[label], models.RunTrigger{
+				Label:      change.Label,
+				ChangeType: change.ChangeType,
+			})
+		}
+	}
+
+	return &models.RunPlan{
+		Run:     runTargets(run),
+		Removed: runTargets(removed),
+	}
+}
+
+// runTargets returns run targets sorted by label, with their triggers
+// sorted by label.
+func runTargets(targets map[string][]models.RunTrigger) []models.RunTarget {
+	result := make([]models.RunTarget, 0, len(targets))
+	for _, label := range slices.Sorted(maps.Keys(targets)) {
+		triggers := targets[label]
+		slices.SortFunc(triggers, func(a, b models.RunTrigger) int {
+			return cmp.Compare(a.Label, b.Label)
+		})
+		result = append(result, models.RunTarget{Label: label, Triggers: triggers})
+	}
+	return result
+}
+
+// DiffOutputRunPlan writes the run plan of the changes as JSON (see
+// NewRunPlan).
+func (*differ) DiffOutputRunPlan(dest io.Writer, changes []models.TrackerChange) error {
+	out, err := json.MarshalIndent(NewRunPlan(changes), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run plan: %w", err)
+	}
+
+	_, err = io.Copy(dest, bytes.NewReader(out))
+	return err
+}
@@ -0,0 +1,45 @@
+package differ
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+)
+
+func TestNewRunPlan(t *testing.T) {
+	changes := []models.TrackerChange{
+		{Label: "//svc/b:image", ChangeType: models.Changed, To: &models.Tracker{Digest: "b", Run: []string{"//svc:deploy", "//:notify"}}},
+		{Label: "//svc/a:image", ChangeType: models.Added, To: &models.Tracker{Digest: "a", Run: []string{"//svc:deploy"}}},
+		{
+			Label:      "//svc/c:image",
+			ChangeType: models.MetadataChanged,
+			From:       &models.Tracker{Digest: "c", Run: []string{"//svc/c:deploy"}},
+			To:         &models.Tracker{Digest: "c", Run: []string{"//svc/c:deploy_v2"}},
+		},
+		{Label: "//old:image", ChangeType: models.Removed, From: &models.Tracker{Digest: "d", Run: []string{"//old:teardown"}}},
+		{Label: "//flaky:image", ChangeType: models.Unknown, To: &models.Tracker{Unknown: true, Run: []string{"//flaky:deploy"}}},
+		{Label: "//same:image", ChangeType: models.Unchanged, From: &models.Tracker{Digest: "e", Run: []string{"//same:deploy"}}, To: &models.Tracker{Digest: "e", Run: []string{"//same:deploy"}}},
+	}
+
+	assert.Equal(t, &models.RunPlan{
+		Run: []models.RunTarget{
+			{Label: "//:notify", Triggers: []models.RunTrigger{{Label: "//svc/b:image", ChangeType: models.Changed}}},
+			{Label: "//svc/c:deploy_v2", Triggers: []models.RunTrigger{{Label: "//svc/c:image", ChangeType: models.MetadataChanged}}},
+			{Label: "//svc:deploy", Triggers: []models.RunTrigger{
+				{Label: "//svc/a:image", ChangeType: models.Added},
+				{Label: "//svc/b:image", ChangeType: models.Changed},
+			}},
+		},
+		Removed: []models.RunTarget{
+			{Label: "//old:teardown", Triggers: []models.RunTrigger{{Label: "//old:image", ChangeType: models.Removed}}},
+		},
+	}, NewRunPlan(changes))
+
+	var out bytes.Buffer
+	require.NoError(t, NewDiffer().DiffOutputRunPlan(&out, nil))
+	assert.JSONEq(t, `{"run": [], "removed": []}`, out.String())
+}
@@ -0,0 +1,92 @@
+package differ
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+)
+
+// templateFuncs are the helper functions available in templates, in
+// addition to those of text/template.
+var templateFuncs = template.FuncMap{
+	// join joins strings with a separator, like {{ .Run | join " " }}.
+	"join": func(sep string, s []string) string {
+		return strings.Join(s, sep)
+	},
+	// byChange keeps the changes of some comma-separated change types, like
+	// {{ range byChange "added,changed" . }}.
+	"byChange": func(changeTypes string, changes []models.TrackerChange) []models.TrackerChange {
+		types := strings.Split(changeTypes, ",")
+		var filtered []models.TrackerChange
+		for _, change := range changes {
+			if slices.Contains(types, change.ChangeType.String()) {
+				filtered = append(filtered, change)
+			}
+		}
+		return filtered
+	},
+	// groupByTag groups changes by the tags of their trackers, where a
+	// change is in the group of each of its tags. Changes without tags are
+	// left out.
+	"groupByTag": func(changes []models.TrackerChange) map[string][]models.TrackerChange {
+		groups := make(map[string][]models.TrackerChange)
+		for _, change := range changes {
+			for _, tag := range lastKnown(change).Tags {
+				groups[tag] = append(groups[tag], change)
+			}
+		}
+		return groups
+	},
+	// sort sorts strings, or changes by label.
+	"sort": func(v any) (any, error) {
+		switch v := v.(type) {
+		case []string:
+			return slices.Sorted(slices.Values(v)), nil
+		case []models.TrackerChange:
+			return slices.SortedFunc(slices.Values(v), func(a, b models.TrackerChange) int {
+				return cmp.Compare(a.Label, b.Label)
+			}), nil
+		}
+		return nil, fmt.Errorf("can't sort %T", v)
+	},
+	// tracker returns the tracker of a change in the snapshot diffed to, or
+	// the last known one if it was removed.
+	"tracker": func(change models.TrackerChange) *models.Tracker {
+		return lastKnown(change)
+	},
+}
+
+// ParseTemplate parses a text/template for DiffOutputTemplate. Besides the
+// functions of text/template, it can use join, byChange, groupByTag, sort
+// and tracker (see the README).
+func ParseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// DiffOutputTemplate executes a template (see ParseTemplate) with the added,
+// changed, removed or unknown TrackerChanges, sorted by label.
+func (*differ) DiffOutputTemplate(dest io.Writer, changes []models.TrackerChange, tmpl *template.Template) error {
+	changed := make([]models.TrackerChange, 0, len(changes))
+	for _, change := range changes {
+		if change.ChangeType != models.Unchanged {
+			changed = append(changed, change)
+		}
+	}
+	slices.SortFunc(changed, func(a, b models.TrackerChange) int {
+		return cmp.Compare(a.Label, b.Label)
+	})
+
+	if err := tmpl.Execute(dest, changed); err != nil {
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package differ
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+)
+
+func TestDiffOutputTemplate(t *testing.T) {
+	changes := []models.TrackerChange{
+		{Label: "//:b", ChangeType: models.Changed, To: &models.Tracker{Digest: "b", Run: []string{"//:deploy_b", "//:notify"}, Tags: []string{"k8s"}}},
+		{Label: "//:a", ChangeType: models.Added, To: &models.Tracker{Digest: "a", Run: []string{"//:deploy_a"}, Tags: []string{"k8s", "eu"}}},
+		{Label: "//:c", ChangeType: models.Removed, From: &models.Tracker{Digest: "c", Run: []string{"//:teardown"}, Tags: []string{"eu"}}},
+		{Label: "//:d", ChangeType: models.Unchanged, From: &models.Tracker{Digest: "d"}, To: &models.Tracker{Digest: "d"}},
+	}
+
+	for _, tt := range []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{
+			name:     "Range",
+			template: `{{ range . }}{{ .Label }} {{ .ChangeType }}{{ "\n" }}{{ end }}`,
+			want:     "//:a added\n//:b changed\n//:c removed\n",
+		},
+		{
+			name:     "ByChange",
+			template: `DEPLOY=({{ range byChange "added,changed" . }}{{ (tracker .).Run | sort | join " " }} {{ end }})`,
+			want:     "DEPLOY=(//:deploy_a //:deploy_b //:notify )",
+		},
+		{
+			name:     "GroupByTag",
+			template: `{{ range $tag, $changes := groupByTag . }}{{ $tag }}:{{ range sort $changes }} {{ .Label }}{{ end }}{{ "\n" }}{{ end }}`,
+			want:     "eu: //:a //:c\nk8s: //:a //:b\n",
+		},
+		{
+			name:     "Removed",
+			template: `{{ range byChange "removed" . }}{{ join "," .From.Run }}{{ end }}`,
+			want:     "//:teardown",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseTemplate("test", tt.template)
+			require.NoError(t, err)
+
+			var out bytes.Buffer
+			require.NoError(t, NewDiffer().DiffOutputTemplate(&out, changes, tmpl))
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func TestDiffOutputTemplateErrors(t *testing.T) {
+	_, err := ParseTemplate("test", `{{ range . }`)
+	assert.ErrorContains(t, err, "failed to parse template")
+
+	tmpl, err := ParseTemplate("test", `{{ sort 1 }}`)
+	require.NoError(t, err)
+	err = NewDiffer().DiffOutputTemplate(&bytes.Buffer{}, nil, tmpl)
+	assert.ErrorContains(t, err, "can't sort int")
+}
@@ -52,7 +52,10 @@ func (d *digester) Digest(args *DigestArgs) error {
 
 	ct.Digest = fmt.Sprintf("%x", h.Sum(nil))
 
-	content, err := json.Marshal(ct)
+	content, err := json.Marshal(models.TrackerFile{
+		Version: models.TrackerVersion,
+		Tracker: *ct,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to render json file: %w", err)
 	}
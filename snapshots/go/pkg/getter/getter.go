@@ -3,16 +3,18 @@ package getter
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"iter"
-	"path"
-	"strings"
+	"regexp"
+	"strconv"
+	"time"
 
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/snapshotfile"
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/tagger"
 )
 
 type Storage interface {
@@ -31,13 +33,33 @@ func NewGetter(store Storage) *getter {
 	return &getter{store: store}
 }
 
+// tagHistoryRef matches references to earlier targets of a tag, like
+// deployed@{1} for what deployed pointed at before it was last moved.
+var tagHistoryRef = regexp.MustCompile(`^(.+)@\{(\d+)\}$`)
+
 type GetArgs struct {
 	Name      string
 	SkipNames bool
 	SkipTags  bool
 }
 
+// Get resolves a name to a snapshot. The name is either a tag, the name of
+// a snapshot or a unique prefix of it, or <tag>@{n} for the nth previous
+// target of a tag (<tag>@{0} being its current target).
 func (g *getter) Get(ctx context.Context, args *GetArgs) (*models.Snapshot, error) {
+	if m := tagHistoryRef.FindStringSubmatch(args.Name); m != nil && !args.SkipTags {
+		n, err := strconv.Atoi(m[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag reference %s: %w", args.Name, err)
+		}
+
+		snapshotName, err := g.resolveTagHistory(ctx, m[1], n)
+		if err != nil {
+			return nil, err
+		}
+		return g.read(ctx, snapshotName)
+	}
+
 	var snapshotName string
 	if !args.SkipTags {
 		tagPath := fmt.Sprintf("tags/%s", args.Name)
@@ -58,14 +80,19 @@ func (g *getter) Get(ctx context.Context, args *GetArgs) (*models.Snapshot, erro
 				return nil, fmt.Errorf("failed to list snapshots with prefix %s: %w", prefix, err)
 			}
 
+			name, ok := snapshotfile.Name(obj.Path)
+			if !ok {
+				continue
+			}
+
 			// If we've already found a snapshot name
-			// and there are still more objects with the same prefix,
+			// and there are still more snapshots with the same prefix,
 			// the name is ambiguous.
-			if snapshotName != "" {
+			if snapshotName != "" && snapshotName != name {
 				return nil, fmt.Errorf("ambiguous snapshot name: %s", args.Name)
 			}
 
-			snapshotName = strings.TrimSuffix(path.Base(obj.Path), ".json")
+			snapshotName = name
 		}
 	}
 
@@ -73,15 +100,54 @@ func (g *getter) Get(ctx context.Context, args *GetArgs) (*models.Snapshot, erro
 		return nil, fmt.Errorf("snapshot %s not found", args.Name)
 	}
 
-	snapshotBuffer := new(bytes.Buffer)
-	_, err := g.store.ReadInto(ctx, fmt.Sprintf("snapshots/%s.json", snapshotName), snapshotBuffer)
+	return g.read(ctx, snapshotName)
+}
+
+// resolveTagHistory returns the snapshot a tag pointed at n moves ago.
+func (g *getter) resolveTagHistory(ctx context.Context, tagName string, n int) (string, error) {
+	if n == 0 {
+		snapshotBytes, err := g.store.ReadAll(ctx, fmt.Sprintf("tags/%s", tagName))
+		if err != nil {
+			return "", fmt.Errorf("read tag %q: %w", tagName, err)
+		}
+		return string(snapshotBytes), nil
+	}
+
+	history, err := tagger.History(ctx, g.store, tagName)
+	if err != nil {
+		return "", err
+	}
+
+	// Each entry records the previous target, so the nth previous target is
+	// found in the nth newest entry. This also covers the target the tag had
+	// before its history was recorded.
+	if n > len(history) {
+		return "", fmt.Errorf("tag %s has no history entry %s@{%d}", tagName, tagName, n)
+	}
+
+	// The tag was created by this entry, e.g. after being deleted.
+	if history[n-1].Previous == "" {
+		return "", fmt.Errorf("tag %s did not exist at %s@{%d}, before it was created at %s",
+			tagName, tagName, n, history[n-1].Time.Format(time.RFC3339))
+	}
+	return history[n-1].Previous, nil
+}
+
+// read reads a snapshot by its full name.
+func (g *getter) read(ctx context.Context, snapshotName string) (*models.Snapshot, error) {
+	location, err := snapshotfile.Find(ctx, g.store, snapshotName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find resolved snapshot %q: %w", snapshotName, err)
 	}
 
-	snapshot := &models.Snapshot{}
-	if err := json.Unmarshal(snapshotBuffer.Bytes(), snapshot); err != nil {
-		return nil, fmt.Errorf("snapshot format is invalid: %w", err)
+	snapshotBuffer := new(bytes.Buffer)
+	if _, err := g.store.ReadInto(ctx, location, snapshotBuffer); err != nil {
+		return nil, fmt.Errorf("failed to read resolved snapshot %q: %w", snapshotName, err)
+	}
+
+	snapshot, err := snapshotfile.Decode(snapshotBuffer.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load snapshot %q: %w", snapshotName, err)
 	}
 
 	return snapshot, nil
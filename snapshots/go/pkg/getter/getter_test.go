@@ -3,7 +3,10 @@ package getter
 import (
 	"testing"
 
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/snapshotfile"
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/tagger"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -16,6 +19,7 @@ func TestGet(t *testing.T) {
 		"snapshots/abc123.json": `{"labels": {"//foo": {"digest": "abc123", "run": ["//foo:deploy"]}}}`,
 		"snapshots/abc456.json": `{"labels": {"//foo": {"digest": "abc456", "run": ["//foo:deploy"]}}}`,
 		"tags/latest":           "abc456",
+		"tags/compressed":       "def123",
 		"tags/broken":           "nonexistent",
 	}
 	for file, content := range files {
@@ -23,6 +27,12 @@ func TestGet(t *testing.T) {
 		require.NoError(t, err)
 	}
 
+	compressed, err := snapshotfile.Encode(&models.Snapshot{
+		Labels: map[string]*models.Tracker{"//foo": {Digest: "def123"}},
+	}, snapshotfile.Zstd)
+	require.NoError(t, err)
+	require.NoError(t, store.WriteAll(t.Context(), "snapshots/def123.json.zst", compressed))
+
 	getter := NewGetter(store)
 
 	t.Run("ByTag", func(t *testing.T) {
@@ -46,6 +56,15 @@ func TestGet(t *testing.T) {
 		require.Equal(t, "abc123", snapshot.Labels["//foo"].Digest)
 	})
 
+	t.Run("Compressed", func(t *testing.T) {
+		for _, name := range []string{"compressed", "def1"} {
+			snapshot, err := getter.Get(t.Context(), &GetArgs{Name: name})
+			require.NoError(t, err)
+			require.NotNil(t, snapshot)
+			require.Equal(t, "def123", snapshot.Labels["//foo"].Digest)
+		}
+	})
+
 	t.Run("ByNameAmbiguous", func(t *testing.T) {
 		_, err := getter.Get(t.Context(), &GetArgs{Name: "abc", SkipTags: true})
 		require.Error(t, err)
@@ -64,3 +83,73 @@ func TestGet(t *testing.T) {
 		assert.ErrorContains(t, err, "find resolved snapshot")
 	})
 }
+
+func TestGetTagHistory(t *testing.T) {
+	store, err := storage.NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	files := map[string]string{
+		"snapshots/abc123.json": `{"labels": {"//foo": {"digest": "abc123", "run": ["//foo:deploy"]}}}`,
+		"snapshots/abc456.json": `{"labels": {"//foo": {"digest": "abc456", "run": ["//foo:deploy"]}}}`,
+		"snapshots/abc789.json": `{"labels": {"//foo": {"digest": "abc789", "run": ["//foo:deploy"]}}}`,
+		// set before tag history was recorded
+		"tags/deployed": "abc123",
+	}
+	for file, content := range files {
+		err := store.WriteAll(t.Context(), file, []byte(content))
+		require.NoError(t, err)
+	}
+
+	for _, name := range []string{"abc456", "abc789"} {
+		_, err := tagger.NewTagger(store).Tag(t.Context(), &tagger.TagArgs{SnapshotName: name, TagName: "deployed"})
+		require.NoError(t, err)
+	}
+
+	getter := NewGetter(store)
+
+	for ref, digest := range map[string]string{
+		"deployed":     "abc789",
+		"deployed@{0}": "abc789",
+		"deployed@{1}": "abc456",
+		"deployed@{2}": "abc123",
+	} {
+		t.Run(ref, func(t *testing.T) {
+			snapshot, err := getter.Get(t.Context(), &GetArgs{Name: ref})
+			require.NoError(t, err)
+			require.NotNil(t, snapshot)
+			require.Equal(t, digest, snapshot.Labels["//foo"].Digest)
+		})
+	}
+
+	t.Run("TooFarBack", func(t *testing.T) {
+		_, err := getter.Get(t.Context(), &GetArgs{Name: "deployed@{3}"})
+		require.Error(t, err)
+		assert.ErrorContains(t, err, "no history entry")
+	})
+
+	t.Run("Recreated", func(t *testing.T) {
+		tg := tagger.NewTagger(store)
+		_, err := tg.Tag(t.Context(), &tagger.TagArgs{SnapshotName: "abc123", TagName: "recreated"})
+		require.NoError(t, err)
+		_, err = tg.Delete(t.Context(), &tagger.DeleteArgs{TagName: "recreated"})
+		require.NoError(t, err)
+		_, err = tg.Tag(t.Context(), &tagger.TagArgs{SnapshotName: "abc456", TagName: "recreated"})
+		require.NoError(t, err)
+
+		snapshot, err := getter.Get(t.Context(), &GetArgs{Name: "recreated@{0}"})
+		require.NoError(t, err)
+		assert.Equal(t, "abc456", snapshot.Labels["//foo"].Digest)
+
+		// deleted before it was created again
+		_, err = getter.Get(t.Context(), &GetArgs{Name: "recreated@{1}"})
+		assert.ErrorContains(t, err, "tag recreated did not exist at recreated@{1}")
+
+		snapshot, err = getter.Get(t.Context(), &GetArgs{Name: "recreated@{2}"})
+		require.NoError(t, err)
+		assert.Equal(t, "abc123", snapshot.Labels["//foo"].Digest)
+
+		// before it was first created
+		_, err = getter.Get(t.Context(), &GetArgs{Name: "recreated@{3}"})
+		assert.ErrorContains(t, err, "tag recreated did not exist at recreated@{3}")
+	})
+}
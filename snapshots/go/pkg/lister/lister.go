@@ -0,0 +1,188 @@
+package lister
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/snapshotfile"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+)
+
+type Storage interface {
+	ReadAll(ctx context.Context, path string) ([]byte, error)
+	List(ctx context.Context, prefix string) iter.Seq2[storage.ListObject, error]
+	Walk(ctx context.Context, prefix string) iter.Seq2[storage.ListObject, error]
+}
+
+var _ Storage = (*storage.Storage)(nil)
+
+type lister struct {
+	store Storage
+}
+
+func NewLister(store Storage) *lister {
+	return &lister{store: store}
+}
+
+type ListArgs struct {
+	// Prefix limits the snapshots and tags to those with names starting with
+	// the prefix.
+	Prefix string
+
+	// Since limits the snapshots and tags to those modified since then,
+	// if set.
+	Since time.Time
+
+	// SortByTime sorts the snapshots and tags by modification time, newest
+	// first, instead of by name.
+	SortByTime bool
+}
+
+// Snapshot is a stored snapshot.
+type Snapshot struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+
+	// Tags are the tags pointing at the snapshot.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Tag is a stored tag.
+type Tag struct {
+	Name     string    `json:"name"`
+	Snapshot string    `json:"snapshot"`
+	ModTime  time.Time `json:"modTime"`
+}
+
+type ListResult struct {
+	Snapshots []Snapshot `json:"snapshots"`
+	Tags      []Tag      `json:"tags"`
+}
+
+// List lists the snapshots and tags in the storage.
+func (l *lister) List(ctx context.Context, args *ListArgs) (*ListResult, error) {
+	result := &ListResult{
+		Snapshots: []Snapshot{},
+		Tags:      []Tag{},
+	}
+
+	// All tags are read, to find the tags of the listed snapshots.
+	// Tags may be nested, like tags/release/v1.
+	tagsBySnapshot := make(map[string][]string)
+	for obj, err := range l.store.Walk(ctx, "tags/") {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags: %w", err)
+		}
+
+		content, err := l.store.ReadAll(ctx, obj.Path)
+		if errors.Is(err, storage.ErrNotExist) {
+			continue // deleted since it was listed
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tag %s: %w", obj.Path, err)
+		}
+
+		tag := Tag{
+			Name:     strings.TrimPrefix(obj.Path, "tags/"),
+			Snapshot: strings.TrimSpace(string(content)),
+			ModTime:  obj.ModTime,
+		}
+		tagsBySnapshot[tag.Snapshot] = append(tagsBySnapshot[tag.Snapshot], tag.Name)
+		if args.matches(tag.Name, tag.ModTime) {
+			result.Tags = append(result.Tags, tag)
+		}
+	}
+
+	for obj, err := range l.store.List(ctx, "snapshots/"+args.Prefix) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list snapshots: %w", err)
+		}
+		name, ok := snapshotfile.Name(obj.Path)
+		if !ok {
+			continue
+		}
+
+		snapshot := Snapshot{
+			Name:    name,
+			Size:    obj.Size,
+			ModTime: obj.ModTime,
+		}
+		snapshot.Tags = slices.Sorted(slices.Values(tagsBySnapshot[snapshot.Name]))
+		if args.matches(snapshot.Name, snapshot.ModTime) {
+			result.Snapshots = append(result.Snapshots, snapshot)
+		}
+	}
+
+	if args.SortByTime {
+		slices.SortStableFunc(result.Snapshots, func(a, b Snapshot) int {
+			return cmp.Or(b.ModTime.Compare(a.ModTime), cmp.Compare(a.Name, b.Name))
+		})
+		slices.SortStableFunc(result.Tags, func(a, b Tag) int {
+			return cmp.Or(b.ModTime.Compare(a.ModTime), cmp.Compare(a.Name, b.Name))
+		})
+	} else {
+		slices.SortFunc(result.Snapshots, func(a, b Snapshot) int { return cmp.Compare(a.Name, b.Name) })
+		slices.SortFunc(result.Tags, func(a, b Tag) int { return cmp.Compare(a.Name, b.Name) })
+	}
+
+	return result, nil
+}
+
+func (args *ListArgs) matches(name string, modTime time.Time) bool {
+	if !strings.HasPrefix(name, args.Prefix) {
+		return false
+	}
+	return args.Since.IsZero() || !modTime.Before(args.Since)
+}
+
+// ListOutputJSON writes the snapshots and tags as JSON.
+func (*lister) ListOutputJSON(dest io.Writer, result *ListResult) error {
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal list: %w", err)
+	}
+
+	_, err = io.Copy(dest, bytes.NewReader(out))
+	return err
+}
+
+// ListOutputPretty writes human-readable tables of the snapshots and tags.
+func (*lister) ListOutputPretty(dest io.Writer, result *ListResult) error {
+	snapshots := tablewriter.NewWriter(dest)
+	snapshots.Header([]string{"Snapshot", "Size", "Modified", "Tags"})
+	for _, snapshot := range result.Snapshots {
+		snapshots.Append([]string{
+			snapshot.Name,
+			strconv.FormatInt(snapshot.Size, 10),
+			snapshot.ModTime.Local().Format(time.DateTime),
+			strings.Join(snapshot.Tags, "\n"),
+		})
+	}
+	snapshots.Render()
+
+	tags := tablewriter.NewWriter(dest)
+	tags.Header([]string{"Tag", "Snapshot", "Modified"})
+	for _, tag := range result.Tags {
+		tags.Append([]string{
+			tag.Name,
+			tag.Snapshot,
+			tag.ModTime.Local().Format(time.DateTime),
+		})
+	}
+	tags.Render()
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package lister
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewStorage("file://" + dir)
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	files := []struct {
+		path    string
+		content string
+		modTime time.Time
+	}{
+		{"snapshots/abc123.json", `{"labels": {}}`, now.Add(-3 * time.Hour)},
+		{"snapshots/abc456.json", `{"version": 1, "labels": {}}`, now.Add(-1 * time.Hour)},
+		{"snapshots/def789.json", `{"labels": {}}`, now.Add(-2 * time.Hour)},
+		{"tags/deployed", "abc123", now.Add(-1 * time.Hour)},
+		{"tags/latest", "abc456", now.Add(-30 * time.Minute)},
+		{"tags/previous", "abc123", now.Add(-5 * time.Hour)},
+	}
+	for _, f := range files {
+		require.NoError(t, store.WriteAll(t.Context(), f.path, []byte(f.content)))
+		require.NoError(t, os.Chtimes(filepath.Join(dir, f.path), f.modTime, f.modTime))
+	}
+
+	lister := NewLister(store)
+
+	t.Run("All", func(t *testing.T) {
+		got, err := lister.List(t.Context(), &ListArgs{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []Snapshot{
+			{Name: "abc123", Size: 14, ModTime: now.Add(-3 * time.Hour), Tags: []string{"deployed", "previous"}},
+			{Name: "abc456", Size: 28, ModTime: now.Add(-1 * time.Hour), Tags: []string{"latest"}},
+			{Name: "def789", Size: 14, ModTime: now.Add(-2 * time.Hour)},
+		}, normalize(got.Snapshots))
+		assert.Equal(t, []string{"deployed", "latest", "previous"}, tagNames(got.Tags))
+		assert.Equal(t, "abc456", got.Tags[1].Snapshot)
+	})
+
+	t.Run("Prefix", func(t *testing.T) {
+		got, err := lister.List(t.Context(), &ListArgs{Prefix: "abc"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"abc123", "abc456"}, snapshotNames(got.Snapshots))
+		assert.Empty(t, got.Tags)
+	})
+
+	t.Run("Since", func(t *testing.T) {
+		got, err := lister.List(t.Context(), &ListArgs{Since: now.Add(-2 * time.Hour)})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"abc456", "def789"}, snapshotNames(got.Snapshots))
+		assert.Equal(t, []string{"deployed", "latest"}, tagNames(got.Tags))
+	})
+
+	t.Run("SortByTime", func(t *testing.T) {
+		got, err := lister.List(t.Context(), &ListArgs{SortByTime: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"abc456", "def789", "abc123"}, snapshotNames(got.Snapshots))
+		assert.Equal(t, []string{"latest", "deployed", "previous"}, tagNames(got.Tags))
+	})
+
+	t.Run("Output", func(t *testing.T) {
+		got, err := lister.List(t.Context(), &ListArgs{Prefix: "def"})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		require.NoError(t, lister.ListOutputJSON(&buf, got))
+		assert.Contains(t, buf.String(), `"name": "def789"`)
+		assert.Contains(t, buf.String(), `"tags": []`)
+
+		buf.Reset()
+		require.NoError(t, lister.ListOutputPretty(&buf, got))
+		assert.Contains(t, buf.String(), "def789")
+	})
+}
+
+func TestList_nestedTags(t *testing.T) {
+	store, err := storage.NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+	for path, content := range map[string]string{
+		"snapshots/abc123.json": `{"labels": {}}`,
+		"tags/latest":           "abc123",
+		"tags/release/v1":       "abc123",
+	} {
+		require.NoError(t, store.WriteAll(t.Context(), path, []byte(content)))
+	}
+
+	got, err := NewLister(store).List(t.Context(), &ListArgs{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"latest", "release/v1"}, tagNames(got.Tags))
+	assert.Equal(t, []string{"latest", "release/v1"}, got.Snapshots[0].Tags)
+
+	got, err = NewLister(store).List(t.Context(), &ListArgs{Prefix: "release/"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"release/v1"}, tagNames(got.Tags))
+}
+
+func TestList_tagDeletedWhileListing(t *testing.T) {
+	store, err := storage.NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+	for path, content := range map[string]string{
+		"snapshots/abc123.json": `{"labels": {}}`,
+		"tags/latest":           "abc123",
+		"tags/previous":         "abc123",
+	} {
+		require.NoError(t, store.WriteAll(t.Context(), path, []byte(content)))
+	}
+
+	got, err := NewLister(&deleteOnRead{Storage: store, path: "tags/previous"}).List(t.Context(), &ListArgs{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"latest"}, tagNames(got.Tags))
+}
+
+// deleteOnRead deletes a file right before it's read, as if it was deleted
+// after being listed.
+type deleteOnRead struct {
+	*storage.Storage
+	path string
+}
+
+func (s *deleteOnRead) ReadAll(ctx context.Context, path string) ([]byte, error) {
+	if path == s.path {
+		if err := s.Storage.Delete(ctx, path); err != nil {
+			return nil, err
+		}
+	}
+	return s.Storage.ReadAll(ctx, path)
+}
+
+// normalize makes the modification times comparable with ==.
+func normalize(snapshots []Snapshot) []Snapshot {
+	for i := range snapshots {
+		snapshots[i].ModTime = snapshots[i].ModTime.Local()
+	}
+	return snapshots
+}
+
+func snapshotNames(snapshots []Snapshot) []string {
+	var names []string
+	for _, snapshot := range snapshots {
+		names = append(names, snapshot.Name)
+	}
+	return names
+}
+
+func tagNames(tags []Tag) []string {
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	return names
+}
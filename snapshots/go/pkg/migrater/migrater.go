@@ -0,0 +1,117 @@
+package migrater
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/snapshotfile"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+)
+
+type Storage interface {
+	ReadAll(ctx context.Context, path string) ([]byte, error)
+	WriteWith(ctx context.Context, path string, data []byte, opts *storage.WriteOptions) error
+	List(ctx context.Context, prefix string) iter.Seq2[storage.ListObject, error]
+}
+
+var _ Storage = (*storage.Storage)(nil)
+
+type migrater struct {
+	store Storage
+}
+
+func NewMigrater(store Storage) *migrater {
+	return &migrater{store: store}
+}
+
+type MigrateArgs struct {
+	// DryRun only finds the snapshots to migrate, without rewriting them.
+	DryRun bool
+}
+
+type MigrateResult struct {
+	// Migrated are the names of the snapshots which were (or, with DryRun,
+	// would be) rewritten to the current version.
+	Migrated []string
+
+	// Current is the number of snapshots already of the current version.
+	Current int
+}
+
+// Migrate rewrites all snapshots in the storage which are of an older
+// version to the current version (see models.SnapshotVersion).
+// It fails on snapshots of a newer version.
+//
+// Snapshots without a collection time in their metadata get their
+// modification time from before the migration instead, which is when they
+// were pushed, unless pushed again since.
+func (m *migrater) Migrate(ctx context.Context, args *MigrateArgs) (*MigrateResult, error) {
+	result := &MigrateResult{}
+	for obj, err := range m.store.List(ctx, "snapshots/") {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list snapshots: %w", err)
+		}
+		name, ok := snapshotfile.Name(obj.Path)
+		if !ok {
+			continue
+		}
+
+		b, err := m.store.ReadAll(ctx, obj.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %s: %w", name, err)
+		}
+		if b, err = snapshotfile.Decompress(b); err != nil {
+			return nil, fmt.Errorf("invalid snapshot %s: %w", name, err)
+		}
+
+		version, err := models.Version(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot %s: %w", name, err)
+		}
+		if version == models.SnapshotVersion {
+			result.Current++
+			continue
+		}
+
+		// fails for newer versions
+		snapshot, err := models.LoadSnapshot(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load snapshot %s: %w", name, err)
+		}
+
+		// Rewriting the snapshot resets its modification time, so its age
+		// is kept in its metadata instead, for gc (see pruner.Prune).
+		if snapshot.Metadata == nil {
+			snapshot.Metadata = &models.Metadata{}
+		}
+		if snapshot.Metadata.CollectedAt.IsZero() {
+			snapshot.Metadata.CollectedAt = obj.ModTime.UTC()
+		}
+
+		result.Migrated = append(result.Migrated, name)
+		if args.DryRun {
+			log.Printf("would migrate snapshot %s from version %d", name, version)
+			continue
+		}
+
+		// keep the snapshot compressed the same way
+		codec := snapshotfile.CodecOf(obj.Path)
+		snapshotBytes, err := snapshotfile.Encode(snapshot, codec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode snapshot %s: %w", name, err)
+		}
+		opts := &storage.WriteOptions{
+			ContentType:     "application/json",
+			ContentEncoding: codec.ContentEncoding(),
+		}
+		if err := m.store.WriteWith(ctx, obj.Path, snapshotBytes, opts); err != nil {
+			return nil, fmt.Errorf("failed to write snapshot %s: %w", name, err)
+		}
+		log.Printf("migrated snapshot %s from version %d", name, version)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,96 @@
+package migrater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/snapshotfile"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	newStoreIn := func(t *testing.T, dir string, files map[string]string) *storage.Storage {
+		store, err := storage.NewStorage("file://" + dir)
+		require.NoError(t, err)
+		for file, content := range files {
+			require.NoError(t, store.WriteAll(t.Context(), file, []byte(content)))
+		}
+		return store
+	}
+	newStore := func(t *testing.T, files map[string]string) *storage.Storage {
+		return newStoreIn(t, t.TempDir(), files)
+	}
+
+	files := map[string]string{
+		"snapshots/old.json":     `{"labels": {"//foo": {"digest": "abc123"}}}`,
+		"snapshots/current.json": `{"version": 1, "labels": {"//foo": {"digest": "abc456"}}}`,
+		"tags/latest":            "current",
+	}
+
+	t.Run("Migrate", func(t *testing.T) {
+		dir := t.TempDir()
+		store := newStoreIn(t, dir, files)
+		pushedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, "snapshots/old.json"), pushedAt, pushedAt))
+
+		result, err := NewMigrater(store).Migrate(t.Context(), &MigrateArgs{})
+		require.NoError(t, err)
+		assert.Equal(t, &MigrateResult{Migrated: []string{"old"}, Current: 1}, result)
+
+		// the time it was pushed is kept, as the rewrite resets it
+		b, err := store.ReadAll(t.Context(), "snapshots/old.json")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"version": 1, "metadata": {"collectedAt": "2024-01-02T03:04:05Z"}, "labels": {"//foo": {"digest": "abc123"}}}`, string(b))
+
+		// nothing left to migrate
+		result, err = NewMigrater(store).Migrate(t.Context(), &MigrateArgs{})
+		require.NoError(t, err)
+		assert.Equal(t, &MigrateResult{Current: 2}, result)
+	})
+
+	t.Run("DryRun", func(t *testing.T) {
+		store := newStore(t, files)
+
+		result, err := NewMigrater(store).Migrate(t.Context(), &MigrateArgs{DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"old"}, result.Migrated)
+
+		b, err := store.ReadAll(t.Context(), "snapshots/old.json")
+		require.NoError(t, err)
+		assert.Equal(t, files["snapshots/old.json"], string(b))
+	})
+
+	t.Run("Compressed", func(t *testing.T) {
+		compressed, err := snapshotfile.Compress([]byte(files["snapshots/old.json"]), snapshotfile.Gzip)
+		require.NoError(t, err)
+		store := newStore(t, map[string]string{
+			"snapshots/old.json.gz": string(compressed),
+		})
+
+		result, err := NewMigrater(store).Migrate(t.Context(), &MigrateArgs{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"old"}, result.Migrated)
+
+		// still compressed
+		b, err := store.ReadAll(t.Context(), "snapshots/old.json.gz")
+		require.NoError(t, err)
+		snapshot, err := snapshotfile.Decode(b)
+		require.NoError(t, err)
+		assert.Equal(t, models.SnapshotVersion, snapshot.Version)
+		assert.Equal(t, "abc123", snapshot.Labels["//foo"].Digest)
+	})
+
+	t.Run("NewerVersion", func(t *testing.T) {
+		store := newStore(t, map[string]string{
+			"snapshots/new.json": `{"version": 1000, "labels": {}}`,
+		})
+
+		_, err := NewMigrater(store).Migrate(t.Context(), &MigrateArgs{})
+		assert.ErrorIs(t, err, models.ErrUnsupportedVersion)
+	})
+}
@@ -3,18 +3,58 @@
 // package models defines models used internally in snapshots
 package models
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Tracker struct {
 	Digest string   `json:"digest"`
 	Run    []string `json:"run,omitempty"`
 	Tags   []string `json:"tags,omitempty"`
+
+	// Unknown is set for labels which failed to build, so their digest is
+	// not known (see collect --keep-going).
+	Unknown bool `json:"unknown,omitempty"`
+
+	// CarriedForward is set for labels which failed to build, when the
+	// tracker was copied from a base snapshot instead (see collect --base).
+	CarriedForward bool `json:"carriedForward,omitempty"`
 }
 
 type Snapshot struct {
+	// Version is the version of the snapshot format (see SnapshotVersion).
+	// Use LoadSnapshot to read snapshots of older versions.
+	Version int `json:"version"`
+
+	// Metadata describes how the snapshot was made.
+	// Not set in snapshots made by older versions, unless migrated, which
+	// only sets CollectedAt.
+	Metadata *Metadata `json:"metadata,omitempty"`
+
 	Labels map[string]*Tracker `json:"labels"`
 }
 
+// Metadata describes how a snapshot was collected. Fields which weren't
+// known when collecting are empty.
+type Metadata struct {
+	Git          *GitMetadata `json:"git,omitempty"`
+	BazelVersion string       `json:"bazelVersion,omitempty"`
+	Query        string       `json:"query,omitempty"`
+	Host         string       `json:"host,omitempty"`
+	ToolVersion  string       `json:"toolVersion,omitempty"`
+	CollectedAt  time.Time    `json:"collectedAt"`
+	Duration     string       `json:"duration,omitempty"`
+}
+
+// GitMetadata is the state of the git repository a snapshot was collected
+// in.
+type GitMetadata struct {
+	Commit string `json:"commit"`
+	Branch string `json:"branch,omitempty"` // empty if detached
+	Dirty  bool   `json:"dirty"`            // uncommitted changes
+}
+
 type ChangeType int
 
 func (a ChangeType) String() string {
@@ -27,6 +67,10 @@ func (a ChangeType) String() string {
 		return "removed"
 	case Changed:
 		return "changed"
+	case Unknown:
+		return "unknown"
+	case MetadataChanged:
+		return "metadata-changed"
 	}
 	return ""
 }
@@ -40,10 +84,91 @@ const (
 	Added
 	Removed
 	Changed
+	Unknown         // the label failed to build, so it's not known whether it changed
+	MetadataChanged // the run labels or tags changed, but not the digest
 )
 
 type TrackerChange struct {
 	Tracker
 	Label      string     `json:"label"`
 	ChangeType ChangeType `json:"change"`
+
+	// From and To are the trackers of the label in the snapshots diffed
+	// from and to, if it's in them. From is the last known tracker of
+	// removed labels.
+	From *Tracker `json:"from,omitempty"`
+	To   *Tracker `json:"to,omitempty"`
+}
+
+// RunPlan is the run labels to execute for a diff, each only once.
+type RunPlan struct {
+	// Run is the run labels of added or changed trackers.
+	Run []RunTarget `json:"run"`
+
+	// Removed is the run labels of removed trackers, e.g. to tear them down.
+	Removed []RunTarget `json:"removed"`
+}
+
+// RunTarget is a run label, with the changes to the trackers which have it.
+type RunTarget struct {
+	Label    string       `json:"label"`
+	Triggers []RunTrigger `json:"triggers"`
+}
+
+// RunTrigger is a change to a tracker, causing its run labels to be run.
+type RunTrigger struct {
+	Label      string     `json:"label"`
+	ChangeType ChangeType `json:"change"`
+}
+
+// RunStatus is the outcome of executing a run target.
+type RunStatus string
+
+const (
+	RunPlanned   RunStatus = "planned" // not executed, as it was a dry run
+	RunSucceeded RunStatus = "succeeded"
+	RunFailed    RunStatus = "failed"
+	RunTimedOut  RunStatus = "timed-out"
+	RunSkipped   RunStatus = "skipped" // not executed, as another target failed
+)
+
+// RunReport is the outcome of executing a run plan.
+type RunReport struct {
+	Results []RunResult `json:"results"`
+}
+
+// RunResult is the outcome of executing a run target.
+type RunResult struct {
+	RunTarget
+	Status RunStatus `json:"status"`
+
+	// Duration is how long the target ran for, in seconds.
+	Duration float64 `json:"duration,omitempty"`
+
+	// Error describes why the target failed.
+	Error string `json:"error,omitempty"`
+}
+
+// TagHistoryEntry records a tag being moved to (or created for) a snapshot,
+// or being deleted.
+type TagHistoryEntry struct {
+	Tag      string    `json:"tag"`
+	Previous string    `json:"previous,omitempty"` // empty if the tag was created
+	Snapshot string    `json:"snapshot"`           // empty if the tag was deleted
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor,omitempty"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// TagPolicy declares which tags are protected from being moved or deleted
+// by accident. It's stored as tag-policy.json next to the tags.
+type TagPolicy struct {
+	Protected []ProtectedTag `json:"protected"`
+}
+
+// ProtectedTag protects the tags matching a pattern (see path.Match), so
+// they can only be moved or deleted with force, or by one of the actors.
+type ProtectedTag struct {
+	Pattern string   `json:"pattern"`
+	Actors  []string `json:"actors,omitempty"`
 }
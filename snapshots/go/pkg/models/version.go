@@ -0,0 +1,118 @@
+/* Copyright 2022 Cognite AS */
+
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// The current versions of the snapshot and tracker file formats.
+//
+// Version 0 is the format from before there were versions, which is the
+// same as version 1 without the version field.
+const (
+	SnapshotVersion = 1
+	TrackerVersion  = 1
+)
+
+// ErrUnsupportedVersion is returned when loading a snapshot or tracker
+// written by a newer version of snapshots.
+var ErrUnsupportedVersion = errors.New("unsupported version")
+
+// upgrade upgrades a decoded snapshot or tracker file by one version.
+type upgrade func(raw map[string]json.RawMessage) error
+
+// snapshotUpgrades upgrade snapshots from version i to version i+1.
+var snapshotUpgrades = []upgrade{
+	0: upgradeUnversioned,
+}
+
+// trackerUpgrades upgrade tracker files from version i to version i+1.
+var trackerUpgrades = []upgrade{
+	0: upgradeUnversioned,
+}
+
+// upgradeUnversioned upgrades from version 0, which only lacks the version.
+func upgradeUnversioned(map[string]json.RawMessage) error {
+	return nil
+}
+
+// TrackerFile is the tracker file written for each change tracker (see
+// snapshots digest). Trackers in snapshots are versioned with the snapshot
+// instead.
+type TrackerFile struct {
+	Version int `json:"version"`
+	Tracker
+}
+
+// Version returns the version of an encoded snapshot or tracker file.
+func Version(b []byte) (int, error) {
+	var versioned struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(b, &versioned); err != nil {
+		return 0, err
+	}
+	if versioned.Version < 0 {
+		return 0, fmt.Errorf("invalid version %d", versioned.Version)
+	}
+	return versioned.Version, nil
+}
+
+// LoadSnapshot decodes a snapshot, upgrading it to the current version if
+// it was written by an older version of snapshots.
+func LoadSnapshot(b []byte) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	if err := load(b, "snapshot", SnapshotVersion, snapshotUpgrades, snapshot); err != nil {
+		return nil, err
+	}
+	snapshot.Version = SnapshotVersion
+	return snapshot, nil
+}
+
+// LoadTracker decodes a tracker file, upgrading it to the current version
+// if it was written by an older version of snapshots.
+func LoadTracker(b []byte) (*Tracker, error) {
+	file := &TrackerFile{}
+	if err := load(b, "tracker", TrackerVersion, trackerUpgrades, file); err != nil {
+		return nil, err
+	}
+	return &file.Tracker, nil
+}
+
+// load decodes b into v, after applying the upgrades from its version to
+// the current version.
+func load(b []byte, kind string, current int, upgrades []upgrade, v any) error {
+	version, err := Version(b)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", kind, err)
+	}
+	if version > current {
+		return fmt.Errorf("%w: %s version %d is newer than version %d, "+
+			"which is the latest known by this version of snapshots; please upgrade snapshots",
+			ErrUnsupportedVersion, kind, version, current)
+	}
+
+	if version < current {
+		raw := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return fmt.Errorf("invalid %s: %w", kind, err)
+		}
+		for ; version < current; version++ {
+			if err := upgrades[version](raw); err != nil {
+				return fmt.Errorf("failed to upgrade %s from version %d: %w", kind, version, err)
+			}
+		}
+
+		if b, err = json.Marshal(raw); err != nil {
+			return fmt.Errorf("failed to upgrade %s: %w", kind, err)
+		}
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("invalid %s: %w", kind, err)
+	}
+	return nil
+}
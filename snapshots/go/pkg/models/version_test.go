@@ -0,0 +1,62 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSnapshot(t *testing.T) {
+	t.Run("Unversioned", func(t *testing.T) {
+		got, err := LoadSnapshot([]byte(`{"labels": {"//foo": {"digest": "abc", "run": ["//foo:deploy"]}}}`))
+		require.NoError(t, err)
+		assert.Equal(t, &Snapshot{
+			Version: SnapshotVersion,
+			Labels: map[string]*Tracker{
+				"//foo": {Digest: "abc", Run: []string{"//foo:deploy"}},
+			},
+		}, got)
+	})
+
+	t.Run("Current", func(t *testing.T) {
+		got, err := LoadSnapshot([]byte(`{"version": 1, "labels": {"//foo": {"digest": "abc"}}}`))
+		require.NoError(t, err)
+		assert.Equal(t, SnapshotVersion, got.Version)
+		assert.Equal(t, "abc", got.Labels["//foo"].Digest)
+	})
+
+	t.Run("Newer", func(t *testing.T) {
+		_, err := LoadSnapshot([]byte(`{"version": 1000, "labels": {}}`))
+		assert.ErrorIs(t, err, ErrUnsupportedVersion)
+		assert.ErrorContains(t, err, "snapshot version 1000 is newer than version 1")
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := LoadSnapshot([]byte(`{"version": -1}`))
+		assert.ErrorContains(t, err, "invalid snapshot: invalid version -1")
+
+		_, err = LoadSnapshot([]byte(`[]`))
+		assert.ErrorContains(t, err, "invalid snapshot")
+	})
+}
+
+func TestLoadTracker(t *testing.T) {
+	t.Run("Unversioned", func(t *testing.T) {
+		got, err := LoadTracker([]byte(`{"digest": "abc", "tags": ["foo"]}`))
+		require.NoError(t, err)
+		assert.Equal(t, &Tracker{Digest: "abc", Tags: []string{"foo"}}, got)
+	})
+
+	t.Run("Current", func(t *testing.T) {
+		got, err := LoadTracker([]byte(`{"version": 1, "digest": "abc"}`))
+		require.NoError(t, err)
+		assert.Equal(t, &Tracker{Digest: "abc"}, got)
+	})
+
+	t.Run("Newer", func(t *testing.T) {
+		_, err := LoadTracker([]byte(`{"version": 2, "digest": "abc"}`))
+		assert.ErrorIs(t, err, ErrUnsupportedVersion)
+		assert.ErrorContains(t, err, "tracker version 2")
+	})
+}
@@ -0,0 +1,291 @@
+package pruner
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/snapshotfile"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/tagger"
+)
+
+type Storage interface {
+	ReadAll(ctx context.Context, path string) ([]byte, error)
+	List(ctx context.Context, prefix string) iter.Seq2[storage.ListObject, error]
+	Walk(ctx context.Context, prefix string) iter.Seq2[storage.ListObject, error]
+	Delete(ctx context.Context, path string) error
+}
+
+var _ Storage = (*storage.Storage)(nil)
+
+type pruner struct {
+	store Storage
+	now   func() time.Time
+}
+
+func NewPruner(store Storage) *pruner {
+	return &pruner{store: store, now: time.Now}
+}
+
+type PruneArgs struct {
+	// MaxAge keeps snapshots collected less than MaxAge ago, if set.
+	MaxAge time.Duration
+
+	// KeepLatest keeps the KeepLatest most recently collected snapshots,
+	// if set.
+	KeepLatest int
+
+	// KeepHistory keeps the snapshots in the KeepHistory latest entries of
+	// the history of every tag. Snapshots which are currently tagged are
+	// always kept.
+	KeepHistory int
+
+	// DryRun only finds the snapshots to delete, without deleting them.
+	DryRun bool
+}
+
+type PruneResult struct {
+	// Deleted are the names of the snapshots which were (or, with DryRun,
+	// would be) deleted.
+	Deleted []string
+
+	// Kept is the number of snapshots which were kept.
+	Kept int
+}
+
+// Prune deletes the snapshots which aren't kept by any of MaxAge and
+// KeepLatest, and which aren't referenced by a tag or its recent history.
+//
+// The age of a snapshot is from when it was collected, in its metadata, as
+// snapshots are rewritten by migrate and push --overwrite. Snapshots without
+// one are as old as their last modification.
+//
+// As tags may be moved while pruning, e.g. to roll back to an old snapshot,
+// the tags are listed again right before each snapshot is deleted, and the
+// ones which changed since they were read are read again. A tag moved
+// between then and the deletion can still point at a deleted snapshot.
+func (p *pruner) Prune(ctx context.Context, args *PruneArgs) (*PruneResult, error) {
+	if args.MaxAge <= 0 && args.KeepLatest <= 0 {
+		return nil, errors.New("one of max age or the number of snapshots to keep must be set")
+	}
+
+	tags, err := p.readTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	referenced, err := p.referenced(ctx, tags, args.KeepHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []storedSnapshot
+	for obj, err := range p.store.List(ctx, "snapshots/") {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list snapshots: %w", err)
+		}
+		name, ok := snapshotfile.Name(obj.Path)
+		if !ok {
+			continue
+		}
+
+		collectedAt, err := p.collectedAt(ctx, obj)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %s: %w", name, err)
+		}
+		snapshots = append(snapshots, storedSnapshot{name: name, path: obj.Path, collectedAt: collectedAt})
+	}
+	slices.SortStableFunc(snapshots, func(a, b storedSnapshot) int {
+		return cmp.Or(b.collectedAt.Compare(a.collectedAt), cmp.Compare(a.path, b.path))
+	})
+
+	result := &PruneResult{}
+	now := p.now()
+	for i, snapshot := range snapshots {
+		name := snapshot.name
+		if referenced[name] ||
+			i < args.KeepLatest ||
+			(args.MaxAge > 0 && now.Sub(snapshot.collectedAt) < args.MaxAge) {
+			result.Kept++
+			continue
+		}
+
+		if args.DryRun {
+			result.Deleted = append(result.Deleted, name)
+			log.Printf("would delete snapshot %s, collected %s", name, snapshot.collectedAt.Local().Format(time.DateTime))
+			continue
+		}
+
+		tagged, err := p.retagged(ctx, tags, name)
+		if err != nil {
+			return nil, err
+		}
+		if tagged {
+			result.Kept++
+			log.Printf("keeping snapshot %s, which was tagged while pruning", name)
+			continue
+		}
+
+		result.Deleted = append(result.Deleted, name)
+		if err := p.store.Delete(ctx, snapshot.path); err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return nil, fmt.Errorf("failed to delete snapshot %s: %w", name, err)
+		}
+		log.Printf("deleted snapshot %s, collected %s", name, snapshot.collectedAt.Local().Format(time.DateTime))
+	}
+
+	return result, nil
+}
+
+// storedSnapshot is a snapshot in the storage, with its age.
+type storedSnapshot struct {
+	name        string
+	path        string
+	collectedAt time.Time
+}
+
+// collectedAt returns when a stored snapshot was collected, from its
+// metadata, or else when it was last modified.
+func (p *pruner) collectedAt(ctx context.Context, obj storage.ListObject) (time.Time, error) {
+	b, err := p.store.ReadAll(ctx, obj.Path)
+	if errors.Is(err, storage.ErrNotExist) {
+		return obj.ModTime, nil // deleted since it was listed
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if b, err = snapshotfile.Decompress(b); err != nil {
+		return time.Time{}, err
+	}
+
+	// Only the metadata is read, so snapshots of any version can be pruned.
+	var snapshot struct {
+		Metadata *models.Metadata `json:"metadata"`
+	}
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return time.Time{}, fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	if snapshot.Metadata == nil || snapshot.Metadata.CollectedAt.IsZero() {
+		return obj.ModTime, nil
+	}
+	return snapshot.Metadata.CollectedAt, nil
+}
+
+// referenced returns the names of the snapshots which are tagged, or in the
+// keepHistory latest entries of the history of a tag.
+func (p *pruner) referenced(ctx context.Context, tags map[string]tagState, keepHistory int) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	for _, tag := range tags {
+		referenced[tag.snapshot] = true
+	}
+
+	if keepHistory <= 0 {
+		return referenced, nil
+	}
+
+	// Tags are found from their history, as deleted tags still have one.
+	tagNames := make(map[string]bool)
+	for obj, err := range p.store.Walk(ctx, "tag-history/") {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tag history: %w", err)
+		}
+		tagNames[strings.TrimPrefix(path.Dir(obj.Path), "tag-history/")] = true
+	}
+
+	for tagName := range tagNames {
+		history, err := tagger.History(ctx, p.store, tagName)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range history[:min(keepHistory, len(history))] {
+			referenced[entry.Snapshot] = true
+			referenced[entry.Previous] = true
+		}
+	}
+
+	return referenced, nil
+}
+
+// tagState is a tag as it was read.
+type tagState struct {
+	modTime  time.Time
+	snapshot string
+}
+
+// readTags reads all tags, by path.
+func (p *pruner) readTags(ctx context.Context) (map[string]tagState, error) {
+	tags := make(map[string]tagState)
+
+	// Tags may be nested, like tags/deployed/eu.
+	for obj, err := range p.store.Walk(ctx, "tags/") {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags: %w", err)
+		}
+
+		tag, ok, err := p.readTag(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			tags[obj.Path] = tag
+		}
+	}
+
+	return tags, nil
+}
+
+// retagged returns whether a snapshot is tagged now, given the tags as they
+// were read before. Only tags which were added or modified since are read
+// again, and updated in tags.
+func (p *pruner) retagged(ctx context.Context, tags map[string]tagState, name string) (bool, error) {
+	for obj, err := range p.store.Walk(ctx, "tags/") {
+		if err != nil {
+			return false, fmt.Errorf("failed to list tags: %w", err)
+		}
+
+		tag, ok := tags[obj.Path]
+		if !ok || !tag.modTime.Equal(obj.ModTime) {
+			if tag, ok, err = p.readTag(ctx, obj); err != nil {
+				return false, err
+			}
+			if !ok {
+				continue
+			}
+			tags[obj.Path] = tag
+		}
+
+		if tag.snapshot == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// readTag reads a listed tag. Returns false if it was deleted since it was
+// listed.
+func (p *pruner) readTag(ctx context.Context, obj storage.ListObject) (tagState, bool, error) {
+	content, err := p.store.ReadAll(ctx, obj.Path)
+	if errors.Is(err, storage.ErrNotExist) {
+		return tagState{}, false, nil
+	}
+	if err != nil {
+		return tagState{}, false, fmt.Errorf("failed to read tag %s: %w", obj.Path, err)
+	}
+
+	return tagState{
+		modTime:  obj.ModTime,
+		snapshot: strings.TrimSpace(string(content)),
+	}, true, nil
+}
@@ -0,0 +1,214 @@
+package pruner
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/migrater"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/tagger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+
+	// snap1 is the newest, modified an hour ago, and snap5 the oldest.
+	setup := func(t *testing.T) *storage.Storage {
+		dir := t.TempDir()
+		store, err := storage.NewStorage("file://" + dir)
+		require.NoError(t, err)
+
+		for i := 1; i <= 5; i++ {
+			name := fmt.Sprintf("snap%d", i)
+			require.NoError(t, store.WriteAll(t.Context(), "snapshots/"+name+".json", []byte(`{"labels":{}}`)))
+
+			modTime := now.Add(-time.Duration(i) * time.Hour)
+			require.NoError(t, os.Chtimes(filepath.Join(dir, "snapshots", name+".json"), modTime, modTime))
+		}
+
+		require.NoError(t, store.WriteAll(t.Context(), "tags/deployed/eu", []byte("snap4")))
+
+		// snap5 is only in the history of rollback
+		tag := tagger.NewTagger(store)
+		for _, name := range []string{"snap5", "snap1"} {
+			_, err := tag.Tag(t.Context(), &tagger.TagArgs{SnapshotName: name, TagName: "rollback"})
+			require.NoError(t, err)
+		}
+
+		return store
+	}
+
+	for _, tt := range []struct {
+		name        string
+		args        PruneArgs
+		wantDeleted []string
+	}{
+		{
+			name:        "KeepLatest",
+			args:        PruneArgs{KeepLatest: 2},
+			wantDeleted: []string{"snap3", "snap5"},
+		},
+		{
+			name:        "MaxAge",
+			args:        PruneArgs{MaxAge: 150 * time.Minute},
+			wantDeleted: []string{"snap3", "snap5"},
+		},
+		{
+			name:        "KeepHistory",
+			args:        PruneArgs{KeepLatest: 2, KeepHistory: 10},
+			wantDeleted: []string{"snap3"},
+		},
+		{
+			name:        "EitherKeeps",
+			args:        PruneArgs{KeepLatest: 1, MaxAge: 150 * time.Minute},
+			wantDeleted: []string{"snap3", "snap5"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			store := setup(t)
+			pruner := NewPruner(store)
+			pruner.now = func() time.Time { return now }
+
+			result, err := pruner.Prune(t.Context(), &tt.args)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantDeleted, result.Deleted)
+			assert.Equal(t, 5-len(tt.wantDeleted), result.Kept)
+
+			for i := 1; i <= 5; i++ {
+				name := fmt.Sprintf("snap%d", i)
+				_, err := store.Stat(t.Context(), "snapshots/"+name+".json")
+				if slices.Contains(tt.wantDeleted, name) {
+					assert.ErrorIs(t, err, storage.ErrNotExist, name)
+				} else {
+					assert.NoError(t, err, name)
+				}
+			}
+		})
+	}
+
+	t.Run("DryRun", func(t *testing.T) {
+		store := setup(t)
+		result, err := NewPruner(store).Prune(t.Context(), &PruneArgs{KeepLatest: 1, DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"snap2", "snap3", "snap5"}, result.Deleted)
+
+		for i := 1; i <= 5; i++ {
+			_, err := store.Stat(t.Context(), fmt.Sprintf("snapshots/snap%d.json", i))
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("NothingToKeep", func(t *testing.T) {
+		_, err := NewPruner(setup(t)).Prune(t.Context(), &PruneArgs{})
+		assert.Error(t, err)
+	})
+}
+
+// tagOnList tags a snapshot when the snapshots are listed, like a tag
+// command running while pruning.
+type tagOnList struct {
+	*storage.Storage
+	tag, snapshot string
+}
+
+func (s *tagOnList) List(ctx context.Context, prefix string) iter.Seq2[storage.ListObject, error] {
+	if prefix == "snapshots/" {
+		if err := s.WriteAll(ctx, "tags/"+s.tag, []byte(s.snapshot)); err != nil {
+			panic(err)
+		}
+	}
+	return s.Storage.List(ctx, prefix)
+}
+
+func TestPruneTaggedWhilePruning(t *testing.T) {
+	store, err := storage.NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+	for _, name := range []string{"snap1", "snap2"} {
+		require.NoError(t, store.WriteAll(t.Context(), "snapshots/"+name+".json", []byte(`{"labels":{}}`)))
+	}
+
+	pruner := NewPruner(&tagOnList{Storage: store, tag: "rollback", snapshot: "snap1"})
+	result, err := pruner.Prune(t.Context(), &PruneArgs{MaxAge: time.Nanosecond})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"snap2"}, result.Deleted)
+	assert.Equal(t, 1, result.Kept)
+
+	_, err = store.Stat(t.Context(), "snapshots/snap1.json")
+	assert.NoError(t, err)
+}
+
+// countTagReads counts the reads of tags.
+type countTagReads struct {
+	*storage.Storage
+	reads int
+}
+
+func (s *countTagReads) ReadAll(ctx context.Context, path string) ([]byte, error) {
+	if strings.HasPrefix(path, "tags/") {
+		s.reads++
+	}
+	return s.Storage.ReadAll(ctx, path)
+}
+
+func TestPruneRereadsChangedTags(t *testing.T) {
+	store, err := storage.NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, store.WriteAll(t.Context(), fmt.Sprintf("snapshots/snap%d.json", i), []byte(`{"labels":{}}`)))
+	}
+	for _, tag := range []string{"a", "b", "c"} {
+		require.NoError(t, store.WriteAll(t.Context(), "tags/"+tag, []byte("snap1")))
+	}
+
+	counting := &countTagReads{Storage: store}
+	result, err := NewPruner(counting).Prune(t.Context(), &PruneArgs{MaxAge: time.Nanosecond})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"snap2", "snap3", "snap4", "snap5"}, result.Deleted)
+
+	// each tag is read once, as none of them changed while pruning
+	assert.Equal(t, 3, counting.reads)
+}
+
+func TestPruneRewritten(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	store, err := storage.NewStorage("file://" + dir)
+	require.NoError(t, err)
+
+	// old was pushed 10 hours ago by an older version, without metadata
+	require.NoError(t, store.WriteAll(t.Context(), "snapshots/old.json", []byte(`{"labels":{}}`)))
+	pushedAt := now.Add(-10 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "snapshots/old.json"), pushedAt, pushedAt))
+
+	// overwritten was collected 20 hours ago, but pushed again just now
+	overwritten := fmt.Sprintf(`{"version":1,"metadata":{"collectedAt":%q},"labels":{}}`, now.Add(-20*time.Hour).Format(time.RFC3339Nano))
+	require.NoError(t, store.WriteAll(t.Context(), "snapshots/overwritten.json", []byte(overwritten)))
+
+	recent := fmt.Sprintf(`{"version":1,"metadata":{"collectedAt":%q},"labels":{}}`, now.Add(-time.Hour).Format(time.RFC3339Nano))
+	require.NoError(t, store.WriteAll(t.Context(), "snapshots/recent.json", []byte(recent)))
+
+	// migrating rewrites old, so it's modified just now too
+	migrated, err := migrater.NewMigrater(store).Migrate(t.Context(), &migrater.MigrateArgs{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"old"}, migrated.Migrated)
+
+	for _, args := range []PruneArgs{
+		{MaxAge: 5 * time.Hour, DryRun: true},
+		{KeepLatest: 1, DryRun: true},
+	} {
+		pruner := NewPruner(store)
+		pruner.now = func() time.Time { return now }
+		result, err := pruner.Prune(t.Context(), &args)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"old", "overwritten"}, result.Deleted)
+	}
+}
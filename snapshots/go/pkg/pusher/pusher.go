@@ -1,21 +1,33 @@
 package pusher
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"iter"
 
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/snapshotfile"
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
 )
 
 type Storage interface {
-	WriteAll(ctx context.Context, location string, data []byte) error
+	ReadAll(ctx context.Context, location string) ([]byte, error)
+	WriteWith(ctx context.Context, location string, data []byte, opts *storage.WriteOptions) error
+	Delete(ctx context.Context, location string) error
 	Stat(ctx context.Context, location string) (*storage.ObjectMetadata, error)
+	List(ctx context.Context, prefix string) iter.Seq2[storage.ListObject, error]
 }
 
 var _ Storage = (*storage.Storage)(nil)
 
+// ErrSnapshotExists is returned when pushing a snapshot with the name of a
+// different, existing snapshot (see PushArgs.Overwrite).
+var ErrSnapshotExists = errors.New("snapshot already exists")
+
 type pusher struct {
 	store Storage
 }
@@ -27,26 +39,147 @@ func NewPusher(store Storage) *pusher {
 type PushArgs struct {
 	Name     string
 	Snapshot *models.Snapshot
+
+	// Overwrite replaces an existing snapshot of the same name, if it's
+	// different.
+	Overwrite bool
+
+	// Codec is how the snapshot is compressed. Defaults to no compression.
+	Codec snapshotfile.Codec
 }
 
-func (p *pusher) Push(ctx context.Context, args *PushArgs) (*storage.ObjectMetadata, error) {
+type PushResult struct {
+	Object *storage.ObjectMetadata
+
+	// Identical is set if an identical snapshot already existed, which was
+	// kept. Snapshots are identical if their labels are, regardless of
+	// their metadata.
+	Identical bool
+
+	// Overwritten is set if a different snapshot was replaced.
+	Overwritten bool
+}
+
+// Push writes a snapshot, unless a snapshot of the same name exists, so
+// snapshots which may be tagged are not replaced by accident. It's not an
+// error if the existing snapshot is identical. Otherwise, ErrSnapshotExists
+// is returned, unless args.Overwrite is set.
+//
+// Of concurrent pushes of the same name, only one snapshot is kept, even if
+// they're compressed differently, and the others are handled as if it
+// existed before.
+func (p *pusher) Push(ctx context.Context, args *PushArgs) (*PushResult, error) {
 	if args.Snapshot == nil {
 		return nil, fmt.Errorf("no snapshot specified")
 	}
 
-	snapshotBytes, err := json.MarshalIndent(args.Snapshot, "", "  ")
+	// snapshots in memory are always of the current version
+	snapshot := *args.Snapshot
+	snapshot.Version = models.SnapshotVersion
+
+	codec := cmp.Or(args.Codec, snapshotfile.None)
+	snapshotBytes, err := snapshotfile.Encode(&snapshot, codec)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal snapshot: %w", err)
+		return nil, err
 	}
 
-	location := fmt.Sprintf("snapshots/%s.json", args.Name)
-	if err := p.store.WriteAll(ctx, location, snapshotBytes); err != nil {
+	location := snapshotfile.Path(args.Name, codec)
+	opts := &storage.WriteOptions{
+		ContentType:     "application/json",
+		ContentEncoding: codec.ContentEncoding(),
+	}
+
+	// The snapshot may exist, compressed with another codec.
+	existing, err := snapshotfile.Find(ctx, p.store, args.Name)
+	if errors.Is(err, storage.ErrNotExist) {
+		createOpts := *opts
+		createOpts.Precondition = &storage.Precondition{DoesNotExist: true}
+		err = p.store.WriteWith(ctx, location, snapshotBytes, &createOpts)
+		switch {
+		case errors.Is(err, storage.ErrPreconditionFailed):
+			existing, err = location, nil
+		case err == nil:
+			existing, err = p.resolveConcurrent(ctx, args.Name, location)
+		}
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to write to bucket file: %w", err)
 	}
 
-	obj, err := p.store.Stat(ctx, location)
+	result := &PushResult{}
+	if existing != "" {
+		identical, err := p.identical(ctx, existing, &snapshot)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case identical:
+			result.Identical = true
+			location = existing
+		case !args.Overwrite:
+			return nil, fmt.Errorf("%w: %s exists with different content; use --overwrite to replace it", ErrSnapshotExists, args.Name)
+		default:
+			if err := p.store.WriteWith(ctx, location, snapshotBytes, opts); err != nil {
+				return nil, fmt.Errorf("failed to write to bucket file: %w", err)
+			}
+			if existing != location {
+				if err := p.store.Delete(ctx, existing); err != nil {
+					return nil, fmt.Errorf("failed to delete %s: %w", existing, err)
+				}
+			}
+			result.Overwritten = true
+		}
+	}
+
+	result.Object, err = p.store.Stat(ctx, location)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get object details: %w", err)
 	}
-	return obj, nil
+	return result, nil
+}
+
+// resolveConcurrent checks that the snapshot just created at location is
+// the only one of its name. The precondition it was created with only
+// covers its codec, so the same name may have been pushed concurrently with
+// another codec. Then the one written first is kept (see
+// snapshotfile.Find), and the other is deleted again. Returns the snapshot
+// which was kept, if not the one at location.
+func (p *pusher) resolveConcurrent(ctx context.Context, name, location string) (string, error) {
+	kept, err := snapshotfile.Find(ctx, p.store, name)
+	if err != nil {
+		return "", err
+	}
+	if kept == location {
+		return "", nil
+	}
+
+	if err := p.store.Delete(ctx, location); err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return "", fmt.Errorf("failed to delete %s, pushed concurrently with %s: %w", location, kept, err)
+	}
+	return kept, nil
+}
+
+// identical returns whether the snapshot at location has the same labels
+// as snapshot. The labels are compared as encoded, so e.g. nil and empty
+// lists are the same, as they are once stored.
+func (p *pusher) identical(ctx context.Context, location string, snapshot *models.Snapshot) (bool, error) {
+	existingBytes, err := p.store.ReadAll(ctx, location)
+	if err != nil {
+		return false, fmt.Errorf("failed to read existing snapshot: %w", err)
+	}
+	existing, err := snapshotfile.Decode(existingBytes)
+	if err != nil {
+		return false, fmt.Errorf("failed to load existing snapshot: %w", err)
+	}
+
+	existingLabels, err := json.Marshal(existing.Labels)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal existing snapshot: %w", err)
+	}
+	labels, err := json.Marshal(snapshot.Labels)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	return bytes.Equal(existingLabels, labels), nil
 }
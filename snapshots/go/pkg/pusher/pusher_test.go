@@ -1,10 +1,13 @@
 package pusher
 
 import (
+	"context"
 	"crypto/rand"
 	"testing"
+	"time"
 
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/snapshotfile"
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -17,7 +20,7 @@ func TestPush(t *testing.T) {
 	name := rand.Text()
 
 	pusher := NewPusher(store)
-	md, err := pusher.Push(t.Context(), &PushArgs{
+	result, err := pusher.Push(t.Context(), &PushArgs{
 		Name: name,
 		Snapshot: &models.Snapshot{
 			Labels: map[string]*models.Tracker{
@@ -31,11 +34,12 @@ func TestPush(t *testing.T) {
 		},
 	})
 	require.NoError(t, err)
-	assert.Equal(t, md.Path, "snapshots/"+name+".json")
+	assert.Equal(t, result.Object.Path, "snapshots/"+name+".json")
 
-	body, err := store.ReadAll(t.Context(), md.Path)
+	body, err := store.ReadAll(t.Context(), result.Object.Path)
 	require.NoError(t, err)
 	assert.JSONEq(t, `{
+		"version": 1,
 		"labels": {
 			"//path/to:tracker": {
 				"digest": "1234abc",
@@ -46,3 +50,188 @@ func TestPush(t *testing.T) {
 		}
 	}`, string(body))
 }
+
+func TestPushExisting(t *testing.T) {
+	store, err := storage.NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	snapshot := func(digest string) *models.Snapshot {
+		return &models.Snapshot{
+			Metadata: &models.Metadata{CollectedAt: time.Now()},
+			Labels: map[string]*models.Tracker{
+				"//path/to:tracker": {Digest: digest},
+			},
+		}
+	}
+	readDigest := func() string {
+		body, err := store.ReadAll(t.Context(), "snapshots/foo.json")
+		require.NoError(t, err)
+		snapshot, err := models.LoadSnapshot(body)
+		require.NoError(t, err)
+		return snapshot.Labels["//path/to:tracker"].Digest
+	}
+
+	pusher := NewPusher(store)
+	result, err := pusher.Push(t.Context(), &PushArgs{Name: "foo", Snapshot: snapshot("abc")})
+	require.NoError(t, err)
+	assert.False(t, result.Identical)
+	assert.False(t, result.Overwritten)
+
+	t.Run("Identical", func(t *testing.T) {
+		// only the metadata differs
+		result, err := pusher.Push(t.Context(), &PushArgs{Name: "foo", Snapshot: snapshot("abc")})
+		require.NoError(t, err)
+		assert.True(t, result.Identical)
+		assert.False(t, result.Overwritten)
+	})
+
+	t.Run("Different", func(t *testing.T) {
+		_, err := pusher.Push(t.Context(), &PushArgs{Name: "foo", Snapshot: snapshot("def")})
+		assert.ErrorIs(t, err, ErrSnapshotExists)
+		assert.Equal(t, "abc", readDigest())
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		result, err := pusher.Push(t.Context(), &PushArgs{Name: "foo", Snapshot: snapshot("def"), Overwrite: true})
+		require.NoError(t, err)
+		assert.False(t, result.Identical)
+		assert.True(t, result.Overwritten)
+		assert.Equal(t, "def", readDigest())
+	})
+}
+
+func TestPushIdenticalRoundTrip(t *testing.T) {
+	store, err := storage.NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	// empty lists are left out when stored, so they're read back as nil
+	snapshot := &models.Snapshot{
+		Labels: map[string]*models.Tracker{
+			"//path/to:tracker": {Digest: "abc", Run: []string{}, Tags: []string{}},
+		},
+	}
+
+	pusher := NewPusher(store)
+	_, err = pusher.Push(t.Context(), &PushArgs{Name: "foo", Snapshot: snapshot})
+	require.NoError(t, err)
+
+	result, err := pusher.Push(t.Context(), &PushArgs{Name: "foo", Snapshot: snapshot})
+	require.NoError(t, err)
+	assert.True(t, result.Identical)
+
+	// and the other way around
+	body, err := store.ReadAll(t.Context(), "snapshots/foo.json")
+	require.NoError(t, err)
+	stored, err := snapshotfile.Decode(body)
+	require.NoError(t, err)
+	require.Nil(t, stored.Labels["//path/to:tracker"].Run)
+
+	_, err = pusher.Push(t.Context(), &PushArgs{Name: "bar", Snapshot: stored})
+	require.NoError(t, err)
+	result, err = pusher.Push(t.Context(), &PushArgs{Name: "bar", Snapshot: snapshot})
+	require.NoError(t, err)
+	assert.True(t, result.Identical)
+}
+
+func TestPushCompressed(t *testing.T) {
+	store, err := storage.NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	snapshot := func(digest string) *models.Snapshot {
+		return &models.Snapshot{
+			Labels: map[string]*models.Tracker{
+				"//path/to:tracker": {Digest: digest},
+			},
+		}
+	}
+
+	pusher := NewPusher(store)
+	result, err := pusher.Push(t.Context(), &PushArgs{Name: "foo", Snapshot: snapshot("abc"), Codec: snapshotfile.Gzip})
+	require.NoError(t, err)
+	assert.Equal(t, "snapshots/foo.json.gz", result.Object.Path)
+
+	body, err := store.ReadAll(t.Context(), result.Object.Path)
+	require.NoError(t, err)
+	got, err := snapshotfile.Decode(body)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", got.Labels["//path/to:tracker"].Digest)
+
+	// the existing snapshot is found, even if compressed differently
+	result, err = pusher.Push(t.Context(), &PushArgs{Name: "foo", Snapshot: snapshot("abc")})
+	require.NoError(t, err)
+	assert.True(t, result.Identical)
+	assert.Equal(t, "snapshots/foo.json.gz", result.Object.Path)
+
+	_, err = pusher.Push(t.Context(), &PushArgs{Name: "foo", Snapshot: snapshot("def"), Codec: snapshotfile.Zstd})
+	assert.ErrorIs(t, err, ErrSnapshotExists)
+
+	result, err = pusher.Push(t.Context(), &PushArgs{Name: "foo", Snapshot: snapshot("def"), Codec: snapshotfile.Zstd, Overwrite: true})
+	require.NoError(t, err)
+	assert.True(t, result.Overwritten)
+	assert.Equal(t, "snapshots/foo.json.zst", result.Object.Path)
+
+	_, err = store.Stat(t.Context(), "snapshots/foo.json.gz")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+}
+
+// pushFirst pushes another snapshot of the same name right before a
+// snapshot is created, like a concurrent push with another codec.
+type pushFirst struct {
+	*storage.Storage
+	location string
+	snapshot *models.Snapshot
+}
+
+func (s *pushFirst) WriteWith(ctx context.Context, location string, data []byte, opts *storage.WriteOptions) error {
+	if opts.Precondition != nil && opts.Precondition.DoesNotExist {
+		b, err := snapshotfile.Encode(s.snapshot, snapshotfile.CodecOf(s.location))
+		if err != nil {
+			return err
+		}
+		if err := s.Storage.WriteAll(ctx, s.location, b); err != nil {
+			return err
+		}
+	}
+	return s.Storage.WriteWith(ctx, location, data, opts)
+}
+
+func TestPushConcurrentCodecs(t *testing.T) {
+	snapshot := func(digest string) *models.Snapshot {
+		return &models.Snapshot{
+			Version: models.SnapshotVersion,
+			Labels: map[string]*models.Tracker{
+				"//path/to:tracker": {Digest: digest},
+			},
+		}
+	}
+
+	for _, tt := range []struct {
+		name    string
+		digest  string
+		wantErr error
+	}{
+		{name: "Identical", digest: "abc"},
+		{name: "Different", digest: "def", wantErr: ErrSnapshotExists},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := storage.NewStorage("file://" + t.TempDir())
+			require.NoError(t, err)
+			concurrent := &pushFirst{Storage: store, location: "snapshots/foo.json.gz", snapshot: snapshot("abc")}
+
+			result, err := NewPusher(concurrent).Push(t.Context(), &PushArgs{Name: "foo", Snapshot: snapshot(tt.digest), Codec: snapshotfile.Zstd})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.True(t, result.Identical)
+				assert.Equal(t, "snapshots/foo.json.gz", result.Object.Path)
+			}
+
+			// only the snapshot pushed first is kept
+			variants, err := snapshotfile.Variants(t.Context(), store, "foo")
+			require.NoError(t, err)
+			require.Len(t, variants, 1)
+			assert.Equal(t, "snapshots/foo.json.gz", variants[0].Path)
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Package runner executes the run labels of changed trackers.
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/bazel"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+)
+
+// ErrRunFailed is returned when some run targets failed or timed out.
+var ErrRunFailed = errors.New("run targets failed")
+
+// waitDelay is how long to wait for the output of a run target which timed
+// out, e.g. from processes it started, after it was killed.
+const waitDelay = 5 * time.Second
+
+type Bazel interface {
+	RunScript(ctx context.Context, bazelrc, scriptPath, label string) error
+}
+
+var _ Bazel = (*bazel.Client)(nil)
+
+type runner struct {
+	bazel Bazel
+	out   io.Writer
+
+	// outMu serializes the output of targets running at once, so their
+	// lines aren't interleaved.
+	outMu sync.Mutex
+}
+
+// NewRunner creates a runner which writes the output of run targets to out,
+// with each line prefixed by the label of its target.
+func NewRunner(bazel Bazel, out io.Writer) *runner {
+	return &runner{bazel: bazel, out: out}
+}
+
+type RunArgs struct {
+	Targets     []models.RunTarget
+	BazelRcPath string
+
+	// Parallelism is the number of targets to run at once.
+	Parallelism int
+
+	// Timeout is the time each target may run for, if set.
+	Timeout time.Duration
+
+	// KeepGoing runs the remaining targets after one failed.
+	KeepGoing bool
+
+	// DryRun only reports the targets which would be run.
+	DryRun bool
+}
+
+// Run executes the run targets, and reports their outcomes. Bazel only runs
+// one command at a time, so the targets are built one at a time, and the
+// scripts 'bazel run' would execute are run with args.Parallelism.
+// Returns ErrRunFailed, along with the report, if any targets failed.
+func (r *runner) Run(ctx context.Context, args *RunArgs) (*models.RunReport, error) {
+	report := &models.RunReport{Results: make([]models.RunResult, len(args.Targets))}
+	for i, target := range args.Targets {
+		status := models.RunSkipped
+		if args.DryRun {
+			status = models.RunPlanned
+		}
+		report.Results[i] = models.RunResult{RunTarget: target, Status: status}
+	}
+	if args.DryRun || len(args.Targets) == 0 {
+		return report, nil
+	}
+
+	dir, err := os.MkdirTemp("", "snapshots-run")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	scriptPath := func(i int) string {
+		return filepath.Join(dir, fmt.Sprintf("%d.sh", i))
+	}
+
+	var failed atomic.Bool
+	stop := func() bool {
+		return failed.Load() && !args.KeepGoing
+	}
+
+	scripts := make(chan int)
+	var wg sync.WaitGroup
+	for range max(args.Parallelism, 1) {
+		wg.Go(func() {
+			for i := range scripts {
+				if stop() {
+					continue
+				}
+				result := &report.Results[i]
+				r.execute(ctx, args, result, scriptPath(i))
+				if result.Status != models.RunSucceeded {
+					failed.Store(true)
+				}
+			}
+		})
+	}
+
+	for i, target := range args.Targets {
+		if stop() {
+			break
+		}
+		if err := r.bazel.RunScript(ctx, args.BazelRcPath, scriptPath(i), target.Label); err != nil {
+			log.Printf("%s failed: %v", target.Label, err)
+			report.Results[i].Status = models.RunFailed
+			report.Results[i].Error = err.Error()
+			failed.Store(true)
+			continue
+		}
+		scripts <- i
+	}
+	close(scripts)
+	wg.Wait()
+
+	if failed.Load() {
+		return report, ErrRunFailed
+	}
+	return report, nil
+}
+
+// execute runs the script of a target, and records its outcome.
+func (r *runner) execute(ctx context.Context, args *RunArgs, result *models.RunResult, script string) {
+	if args.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, args.Timeout)
+		defer cancel()
+	}
+
+	log.Printf("running %s", result.Label)
+
+	out := &prefixWriter{mu: &r.outMu, out: r.out, prefix: fmt.Sprintf("[%s] ", result.Label)}
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = waitDelay
+
+	start := time.Now()
+	err := cmd.Run()
+	out.Flush()
+	result.Duration = time.Since(start).Seconds()
+
+	switch {
+	case err == nil:
+		result.Status = models.RunSucceeded
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result.Status = models.RunTimedOut
+		result.Error = fmt.Sprintf("timed out after %s", args.Timeout)
+	default:
+		result.Status = models.RunFailed
+		result.Error = err.Error()
+	}
+	if result.Error != "" {
+		log.Printf("%s %s after %.1fs: %s", result.Label, result.Status, result.Duration, result.Error)
+	} else {
+		log.Printf("%s %s after %.1fs", result.Label, result.Status, result.Duration)
+	}
+}
+
+// prefixWriter writes whole lines to out, prefixed with prefix, holding mu.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := w.writeLine(w.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush writes the last line, if it didn't end with a newline.
+func (w *prefixWriter) Flush() {
+	if len(w.buf) > 0 {
+		_ = w.writeLine(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := fmt.Fprintf(w.out, "%s%s", w.prefix, line)
+	return err
+}
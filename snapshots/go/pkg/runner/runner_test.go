@@ -0,0 +1,151 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+)
+
+// fakeBazel writes the scripts of labels, failing to build unknown ones.
+type fakeBazel struct {
+	scripts map[string]string
+
+	mu    sync.Mutex
+	built []string
+}
+
+func (b *fakeBazel) RunScript(ctx context.Context, bazelrc, scriptPath, label string) error {
+	b.mu.Lock()
+	b.built = append(b.built, label)
+	b.mu.Unlock()
+
+	script, ok := b.scripts[label]
+	if !ok {
+		return fmt.Errorf("failed to build %s", label)
+	}
+	return os.WriteFile(scriptPath, []byte("#!/bin/sh\n"+script), 0o755)
+}
+
+func targets(labels ...string) []models.RunTarget {
+	var targets []models.RunTarget
+	for _, label := range labels {
+		targets = append(targets, models.RunTarget{
+			Label:    label,
+			Triggers: []models.RunTrigger{{Label: label + "_image", ChangeType: models.Changed}},
+		})
+	}
+	return targets
+}
+
+func statuses(report *models.RunReport) map[string]models.RunStatus {
+	statuses := make(map[string]models.RunStatus)
+	for _, result := range report.Results {
+		statuses[result.Label] = result.Status
+	}
+	return statuses
+}
+
+func TestRun(t *testing.T) {
+	bazel := &fakeBazel{scripts: map[string]string{
+		"//:a": "echo deploying a\necho done",
+		"//:b": "printf 'no newline'",
+	}}
+	var out bytes.Buffer
+	report, err := NewRunner(bazel, &out).Run(t.Context(), &RunArgs{
+		Targets:     targets("//:a", "//:b"),
+		Parallelism: 2,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]models.RunStatus{
+		"//:a": models.RunSucceeded,
+		"//:b": models.RunSucceeded,
+	}, statuses(report))
+	assert.Equal(t, "//:a_image", report.Results[0].Triggers[0].Label)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.ElementsMatch(t, []string{
+		"[//:a] deploying a",
+		"[//:a] done",
+		"[//:b] no newline",
+	}, lines)
+}
+
+func TestRunDryRun(t *testing.T) {
+	bazel := &fakeBazel{}
+	report, err := NewRunner(bazel, &bytes.Buffer{}).Run(t.Context(), &RunArgs{
+		Targets: targets("//:a", "//:b"),
+		DryRun:  true,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]models.RunStatus{
+		"//:a": models.RunPlanned,
+		"//:b": models.RunPlanned,
+	}, statuses(report))
+	assert.Empty(t, bazel.built)
+}
+
+func TestRunFailures(t *testing.T) {
+	scripts := map[string]string{
+		"//:fails": "exit 1",
+		"//:slow":  "exec sleep 10",
+		"//:ok":    "true",
+	}
+
+	for _, tt := range []struct {
+		name      string
+		targets   []string
+		keepGoing bool
+		want      map[string]models.RunStatus
+	}{
+		{
+			name:    "Stop",
+			targets: []string{"//:fails", "//:ok"},
+			want: map[string]models.RunStatus{
+				"//:fails": models.RunFailed,
+				"//:ok":    models.RunSkipped,
+			},
+		},
+		{
+			name:    "BuildFailure",
+			targets: []string{"//:unknown", "//:ok"},
+			want: map[string]models.RunStatus{
+				"//:unknown": models.RunFailed,
+				"//:ok":      models.RunSkipped,
+			},
+		},
+		{
+			name:      "KeepGoing",
+			targets:   []string{"//:unknown", "//:fails", "//:slow", "//:ok"},
+			keepGoing: true,
+			want: map[string]models.RunStatus{
+				"//:unknown": models.RunFailed,
+				"//:fails":   models.RunFailed,
+				"//:slow":    models.RunTimedOut,
+				"//:ok":      models.RunSucceeded,
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			report, err := NewRunner(&fakeBazel{scripts: scripts}, &bytes.Buffer{}).Run(t.Context(), &RunArgs{
+				Targets:     targets(tt.targets...),
+				Parallelism: 1,
+				Timeout:     100 * time.Millisecond,
+				KeepGoing:   tt.keepGoing,
+			})
+			assert.ErrorIs(t, err, ErrRunFailed)
+			assert.Equal(t, tt.want, statuses(report))
+		})
+	}
+}
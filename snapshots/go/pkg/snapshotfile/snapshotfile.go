@@ -0,0 +1,224 @@
+// Package snapshotfile implements how snapshots are stored: as JSON in
+// snapshots/<name>.json, or compressed in snapshots/<name>.json.gz or
+// snapshots/<name>.json.zst.
+package snapshotfile
+
+import (
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+)
+
+// Codec is how a snapshot is compressed.
+type Codec string
+
+const (
+	None Codec = "none"
+	Gzip Codec = "gzip"
+	Zstd Codec = "zstd"
+)
+
+// codecs are all the codecs, in order of precedence (see Variants).
+var codecs = []Codec{None, Gzip, Zstd}
+
+// ParseCodec parses the name of a codec, where "" is None.
+func ParseCodec(s string) (Codec, error) {
+	switch c := Codec(s); c {
+	case "":
+		return None, nil
+	case None, Gzip, Zstd:
+		return c, nil
+	}
+	return "", fmt.Errorf(`unknown compression %q, must be "none", "gzip" or "zstd"`, s)
+}
+
+// Extension is the extension added to the paths of compressed snapshots.
+func (c Codec) Extension() string {
+	switch c {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// ContentEncoding is the HTTP content encoding of compressed snapshots.
+func (c Codec) ContentEncoding() string {
+	if c == None {
+		return ""
+	}
+	return string(c)
+}
+
+// The magic numbers that compressed snapshots start with.
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Path returns the path of a snapshot in the storage.
+func Path(name string, codec Codec) string {
+	return fmt.Sprintf("snapshots/%s.json%s", name, codec.Extension())
+}
+
+// Name returns the name of the snapshot stored at a path, or false if it's
+// not a snapshot.
+func Name(objPath string) (string, bool) {
+	base := path.Base(objPath)
+	for _, codec := range []Codec{Gzip, Zstd} {
+		if trimmed, ok := strings.CutSuffix(base, codec.Extension()); ok {
+			base = trimmed
+			break
+		}
+	}
+	name, ok := strings.CutSuffix(base, ".json")
+	if !ok {
+		return "", false
+	}
+	return name, true
+}
+
+// CodecOf returns the codec of the snapshot stored at a path.
+func CodecOf(objPath string) Codec {
+	for _, codec := range []Codec{Gzip, Zstd} {
+		if strings.HasSuffix(objPath, ".json"+codec.Extension()) {
+			return codec
+		}
+	}
+	return None
+}
+
+type Storage interface {
+	List(ctx context.Context, prefix string) iter.Seq2[storage.ListObject, error]
+}
+
+var _ Storage = (*storage.Storage)(nil)
+
+// Find returns the path of a snapshot in the storage, whichever way it's
+// compressed. Returns [storage.ErrNotExist] if there is no such snapshot.
+//
+// If the snapshot is stored with more than one codec, e.g. by concurrent
+// pushes, the variant written first is returned (see Variants).
+func Find(ctx context.Context, store Storage, name string) (string, error) {
+	variants, err := Variants(ctx, store, name)
+	if err != nil {
+		return "", err
+	}
+	if len(variants) == 0 {
+		return "", storage.ErrNotExist
+	}
+	return variants[0].Path, nil
+}
+
+// Variants returns the objects a snapshot is stored in, one for each codec
+// it's stored with. They're ordered by modification time, oldest first, and
+// then by codec, so the first is the one that was written first.
+func Variants(ctx context.Context, store Storage, name string) ([]storage.ListObject, error) {
+	var variants []storage.ListObject
+	for obj, err := range store.List(ctx, Path(name, None)) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to find snapshot %s: %w", name, err)
+		}
+		if objName, ok := Name(obj.Path); ok && objName == name {
+			variants = append(variants, obj)
+		}
+	}
+
+	slices.SortFunc(variants, func(a, b storage.ListObject) int {
+		return cmp.Or(
+			a.ModTime.Compare(b.ModTime),
+			cmp.Compare(slices.Index(codecs, CodecOf(a.Path)), slices.Index(codecs, CodecOf(b.Path))),
+		)
+	})
+	return variants, nil
+}
+
+// Encode encodes a snapshot to be stored with a codec.
+func Encode(snapshot *models.Snapshot, codec Codec) ([]byte, error) {
+	snapshotBytes, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	return Compress(snapshotBytes, codec)
+}
+
+// Decode decodes a stored snapshot, however it's compressed.
+func Decode(b []byte) (*models.Snapshot, error) {
+	b, err := Decompress(b)
+	if err != nil {
+		return nil, err
+	}
+	return models.LoadSnapshot(b)
+}
+
+// Compress compresses b with a codec.
+func Compress(b []byte, codec Codec) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch codec {
+	case None:
+		return b, nil
+	case Gzip:
+		w = gzip.NewWriter(&buf)
+	case Zstd:
+		zw, err := zstd.NewWriter(&buf)
+		if err != nil {
+			return nil, err
+		}
+		w = zw
+	default:
+		return nil, fmt.Errorf("unknown compression %q", codec)
+	}
+
+	if _, err := w.Write(b); err != nil {
+		return nil, fmt.Errorf("failed to compress snapshot: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress snapshot: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses b, if it's compressed. The codec is detected
+// from the content rather than the path, as storage backends may
+// decompress gzip-encoded files when reading them.
+func Decompress(b []byte) ([]byte, error) {
+	var r io.Reader
+	switch {
+	case bytes.HasPrefix(b, gzipMagic):
+		gr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress snapshot: %w", err)
+		}
+		r = gr
+	case bytes.HasPrefix(b, zstdMagic):
+		zr, err := zstd.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress snapshot: %w", err)
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return b, nil
+	}
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress snapshot: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,107 @@
+package snapshotfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	snapshot := &models.Snapshot{
+		Version: models.SnapshotVersion,
+		Labels: map[string]*models.Tracker{
+			"//foo": {Digest: "abc123"},
+		},
+	}
+
+	for _, codec := range []Codec{None, Gzip, Zstd} {
+		t.Run(string(codec), func(t *testing.T) {
+			b, err := Encode(snapshot, codec)
+			require.NoError(t, err)
+			if codec == None {
+				assert.Equal(t, byte('{'), b[0])
+			} else {
+				assert.NotEqual(t, byte('{'), b[0])
+			}
+
+			got, err := Decode(b)
+			require.NoError(t, err)
+			assert.Equal(t, snapshot, got)
+		})
+	}
+}
+
+func TestName(t *testing.T) {
+	for objPath, want := range map[string]string{
+		"snapshots/abc.json":     "abc",
+		"snapshots/abc.json.gz":  "abc",
+		"snapshots/abc.json.zst": "abc",
+		"snapshots/abc.gz":       "",
+		"snapshots/abc":          "",
+	} {
+		name, ok := Name(objPath)
+		assert.Equal(t, want != "", ok, objPath)
+		assert.Equal(t, want, name, objPath)
+	}
+
+	assert.Equal(t, Gzip, CodecOf("snapshots/abc.json.gz"))
+	assert.Equal(t, None, CodecOf("snapshots/abc.json"))
+}
+
+func TestFind(t *testing.T) {
+	store, err := storage.NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	for _, objPath := range []string{
+		"snapshots/abc.json.zst",
+		"snapshots/abc.json2.json",
+		"snapshots/abcd.json",
+	} {
+		require.NoError(t, store.WriteAll(t.Context(), objPath, nil))
+	}
+
+	got, err := Find(t.Context(), store, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "snapshots/abc.json.zst", got)
+
+	_, err = Find(t.Context(), store, "ab")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+}
+
+func TestFind_manyCodecs(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewStorage("file://" + dir)
+	require.NoError(t, err)
+
+	now := time.Now()
+	for objPath, modTime := range map[string]time.Time{
+		"snapshots/abc.json.zst": now.Add(-time.Hour),
+		"snapshots/abc.json":     now,
+		"snapshots/def.json.gz":  now,
+		"snapshots/def.json.zst": now,
+		"snapshots/def.json":     now,
+	} {
+		require.NoError(t, store.WriteAll(t.Context(), objPath, nil))
+		require.NoError(t, os.Chtimes(filepath.Join(dir, objPath), modTime, modTime))
+	}
+
+	// the variant written first
+	got, err := Find(t.Context(), store, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "snapshots/abc.json.zst", got)
+
+	// or by codec, if written at the same time
+	variants, err := Variants(t.Context(), store, "def")
+	require.NoError(t, err)
+	var paths []string
+	for _, variant := range variants {
+		paths = append(paths, variant.Path)
+	}
+	assert.Equal(t, []string{"snapshots/def.json", "snapshots/def.json.gz", "snapshots/def.json.zst"}, paths)
+}
@@ -0,0 +1,134 @@
+/* Copyright 2022 Cognite AS */
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"gocloud.dev/blob"
+	"gocloud.dev/blob/azureblob"
+)
+
+// azureURLMux opens azblob:// URLs with azureURLOpener.
+// It's separate from blob.DefaultURLMux,
+// where the scheme is already registered by azureblob.
+var azureURLMux = func() *blob.URLMux {
+	mux := new(blob.URLMux)
+	mux.RegisterBucket(azureblob.Scheme, azureURLOpener{})
+	return mux
+}()
+
+// azureURLOpener opens Azure Blob Storage containers like azureblob's
+// default opener, which takes the credentials from the environment
+// (AZURE_STORAGE_KEY, AZURE_STORAGE_CONNECTION_STRING, etc.).
+// The credentials can also be given with these query parameters:
+//
+//	sas_token:         a SAS token
+//	connection_string: a connection string
+//	managed_identity:  "true" to use the managed identity of the host
+//	client_id:         the client id of a user-assigned managed identity
+type azureURLOpener struct{}
+
+func (azureURLOpener) OpenBucketURL(ctx context.Context, u *url.URL) (*blob.Bucket, error) {
+	q := u.Query()
+	sasToken := q.Get("sas_token")
+	connectionString := q.Get("connection_string")
+	clientID := q.Get("client_id")
+	managedIdentity := clientID != ""
+	if v := q.Get("managed_identity"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid managed_identity %q: %w", v, err)
+		}
+		managedIdentity = managedIdentity || b
+	}
+	for _, param := range []string{"sas_token", "connection_string", "managed_identity", "client_id"} {
+		q.Del(param)
+	}
+
+	methods := 0
+	for _, set := range []bool{sasToken != "", connectionString != "", managedIdentity} {
+		if set {
+			methods++
+		}
+	}
+	if methods > 1 {
+		return nil, fmt.Errorf("only one of sas_token, connection_string and managed_identity can be used")
+	}
+
+	opener := &azureblob.URLOpener{
+		MakeClient:        azureblob.NewDefaultClient,
+		ServiceURLOptions: *azureblob.NewDefaultServiceURLOptions(),
+	}
+
+	switch {
+	case sasToken != "":
+		opener.ServiceURLOptions.SASToken = strings.TrimPrefix(sasToken, "?")
+		opener.MakeClient = func(svcURL azureblob.ServiceURL, containerName azureblob.ContainerName) (*container.Client, error) {
+			containerURL, err := azureContainerURL(svcURL, containerName)
+			if err != nil {
+				return nil, err
+			}
+			return container.NewClientWithNoCredential(containerURL, nil)
+		}
+
+	case connectionString != "":
+		// The service url isn't used, but the account name is required to
+		// make it.
+		if opener.ServiceURLOptions.AccountName == "" {
+			opener.ServiceURLOptions.AccountName = connectionStringAccount(connectionString)
+		}
+		opener.MakeClient = func(_ azureblob.ServiceURL, containerName azureblob.ContainerName) (*container.Client, error) {
+			return container.NewClientFromConnectionString(connectionString, string(containerName), nil)
+		}
+
+	case managedIdentity:
+		options := &azidentity.ManagedIdentityCredentialOptions{}
+		if clientID != "" {
+			options.ID = azidentity.ClientID(clientID)
+		}
+		cred, err := azidentity.NewManagedIdentityCredential(options)
+		if err != nil {
+			return nil, fmt.Errorf("managed identity: %w", err)
+		}
+		opener.MakeClient = func(svcURL azureblob.ServiceURL, containerName azureblob.ContainerName) (*container.Client, error) {
+			containerURL, err := azureContainerURL(svcURL, containerName)
+			if err != nil {
+				return nil, err
+			}
+			return container.NewClient(containerURL, cred, nil)
+		}
+	}
+
+	urlCopy := *u
+	urlCopy.RawQuery = q.Encode()
+	return opener.OpenBucketURL(ctx, &urlCopy)
+}
+
+// azureContainerURL returns the url of a container in an Azure storage
+// account, keeping the SAS token (query) of the service url.
+func azureContainerURL(svcURL azureblob.ServiceURL, containerName azureblob.ContainerName) (string, error) {
+	u, err := url.Parse(string(svcURL))
+	if err != nil {
+		return "", fmt.Errorf("invalid service url: %w", err)
+	}
+	return u.JoinPath(string(containerName)).String(), nil
+}
+
+// connectionStringAccount returns the account name in an Azure storage
+// connection string, e.g.
+// "DefaultEndpointsProtocol=https;AccountName=account;AccountKey=...".
+func connectionStringAccount(connectionString string) string {
+	for part := range strings.SplitSeq(connectionString, ";") {
+		if name, ok := strings.CutPrefix(part, "AccountName="); ok {
+			return name
+		}
+	}
+	return ""
+}
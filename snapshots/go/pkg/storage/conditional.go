@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	azblobblob "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/transfermanager"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+)
+
+// ErrPreconditionFailed indicates that a conditional write was rejected,
+// because the object was changed since it was read.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// Precondition is the condition for a write to happen (see WriteIf).
+type Precondition struct {
+	// DoesNotExist requires that the object does not exist.
+	DoesNotExist bool
+
+	// Generation requires that the object has not changed since it had this
+	// generation (see ObjectMetadata).
+	Generation string
+}
+
+// WriteIf writes the entire content of a file at the specified path,
+// but only if the precondition holds, atomically. Returns
+// [ErrPreconditionFailed] if it doesn't.
+//
+// This uses the conditional writes of the storage backend: generation
+// matches on Google Cloud Storage, If-Match and If-None-Match on S3 and
+// Azure, and a lock file on local storage.
+func (s *Storage) WriteIf(ctx context.Context, path string, bs []byte, cond Precondition) error {
+	return s.WriteWith(ctx, path, bs, &WriteOptions{Precondition: &cond})
+}
+
+func (s *Storage) writeIf(ctx context.Context, path string, bs []byte, cond Precondition, opts *blob.WriterOptions) error {
+	if s.localDir != "" {
+		return s.writeIfLocal(ctx, path, bs, cond, opts)
+	}
+
+	opts.IfNotExist = cond.DoesNotExist
+	if !cond.DoesNotExist {
+		opts.BeforeWrite = func(as func(any) bool) error {
+			return requireGeneration(as, cond.Generation)
+		}
+	}
+
+	err := s.bucket.WriteAll(ctx, path, bs, opts)
+	if gcerrors.Code(err) == gcerrors.FailedPrecondition {
+		return ErrPreconditionFailed
+	}
+	return err
+}
+
+// requireGeneration makes a write depend on the generation of the object,
+// given the driver-specific writer options from [blob.WriterOptions].
+func requireGeneration(as func(any) bool, generation string) error {
+	var gcsObject **gcs.ObjectHandle
+	var s3Input *transfermanager.UploadObjectInput
+	var azureOptions *azblob.UploadStreamOptions
+	switch {
+	case as(&gcsObject):
+		gen, err := strconv.ParseInt(generation, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid generation %q: %w", generation, err)
+		}
+		*gcsObject = (*gcsObject).If(gcs.Conditions{GenerationMatch: gen})
+	case as(&s3Input):
+		s3Input.IfMatch = aws.String(generation)
+	case as(&azureOptions):
+		etag := azcore.ETag(generation)
+		azureOptions.AccessConditions = &azblob.AccessConditions{
+			ModifiedAccessConditions: &azblobblob.ModifiedAccessConditions{
+				IfMatch: &etag,
+			},
+		}
+	default:
+		return errors.New("conditional writes are not supported by this storage")
+	}
+	return nil
+}
+
+// generation returns the generation of an object, from its attributes.
+// For Google Cloud Storage this is the object generation, and for other
+// backends the ETag, which changes whenever the object does.
+func generation(attrs *blob.Attributes) string {
+	var gcsAttrs gcs.ObjectAttrs
+	if attrs.As(&gcsAttrs) {
+		return strconv.FormatInt(gcsAttrs.Generation, 10)
+	}
+	return attrs.ETag
+}
+
+// writeIfLocal implements WriteIf for local storage, which is only atomic
+// with respect to other writers using WriteIf, as they hold the lock file.
+func (s *Storage) writeIfLocal(ctx context.Context, path string, bs []byte, cond Precondition, opts *blob.WriterOptions) error {
+	unlock, err := lockDir(s.localDir)
+	if err != nil {
+		return fmt.Errorf("lock storage: %w", err)
+	}
+	defer unlock()
+
+	obj, err := s.Stat(ctx, path)
+	switch {
+	case errors.Is(err, ErrNotExist):
+		if !cond.DoesNotExist {
+			return ErrPreconditionFailed
+		}
+	case err != nil:
+		return err
+	case cond.DoesNotExist || obj.Generation != cond.Generation:
+		return ErrPreconditionFailed
+	}
+
+	return s.bucket.WriteAll(ctx, path, bs, opts)
+}
@@ -0,0 +1,47 @@
+//go:build !unix
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockFile is the lock file held during conditional writes to local storage.
+const lockFile = ".lock"
+
+// staleLockAge is how old a lock file must be to be taken over, as its
+// holder must have exited without removing it. Conditional writes take far
+// less time than this.
+const staleLockAge = time.Minute
+
+// lockDir takes an exclusive lock on local storage, blocking until it's
+// available. The lock is released by calling unlock.
+//
+// Without flock, the lock file is created exclusively, and removed when
+// unlocking. Lock files left behind by exited processes are taken over
+// once they're stale.
+func lockDir(dir string) (unlock func(), err error) {
+	path := filepath.Join(dir, lockFile)
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("remove stale lock: %w", err)
+			}
+			continue
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
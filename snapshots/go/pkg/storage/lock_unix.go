@@ -0,0 +1,31 @@
+//go:build unix
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockFile is the lock file held during conditional writes to local storage.
+const lockFile = ".lock"
+
+// lockDir takes an exclusive lock on local storage, blocking until it's
+// available. The lock is released by calling unlock, or when the process
+// exits.
+func lockDir(dir string) (unlock func(), err error) {
+	f, err := os.OpenFile(filepath.Join(dir, lockFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
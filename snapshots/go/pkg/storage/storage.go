@@ -9,10 +9,14 @@ import (
 	"fmt"
 	"io"
 	"iter"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"gocloud.dev/blob"
-	_ "gocloud.dev/blob/fileblob"
+	"gocloud.dev/blob/azureblob"
+	"gocloud.dev/blob/fileblob"
 	_ "gocloud.dev/blob/gcsblob"
 	_ "gocloud.dev/blob/s3blob"
 	"gocloud.dev/gcerrors"
@@ -21,6 +25,9 @@ import (
 // Storage stores files in a cloud storage bucket or a local file system.
 type Storage struct {
 	bucket *blob.Bucket
+
+	// localDir is the directory of local storage, or empty.
+	localDir string
 }
 
 // NewStorage builds a storage instance from a storage URL.
@@ -29,19 +36,30 @@ type Storage struct {
 //	file:///path/to/local/storage
 //	gs://bucket-name/subdir
 //	s3://bucket-name/subdir
+//	azblob://container-name/subdir
 //
 // For backwards compatibility, "gcs://" may be in place of "gs://".
 func NewStorage(storageURL string) (*Storage, error) {
 	ctx := context.Background()
 
 	storageURL = transformURL(storageURL)
-	bucket, err := blob.OpenBucket(ctx, storageURL)
+	mux := blob.DefaultURLMux()
+	if strings.HasPrefix(storageURL, azureblob.Scheme+"://") {
+		mux = azureURLMux
+	}
+	bucket, err := mux.OpenBucket(ctx, storageURL)
 	if err != nil {
 		return nil, fmt.Errorf("open bucket: %w", err)
 	}
 
+	var localDir string
+	if u, err := url.Parse(storageURL); err == nil && u.Scheme == fileblob.Scheme {
+		localDir = u.Path
+	}
+
 	return &Storage{
-		bucket: bucket,
+		bucket:   bucket,
+		localDir: localDir,
 	}, nil
 }
 
@@ -63,7 +81,44 @@ func (s *Storage) ReadAll(ctx context.Context, path string) ([]byte, error) {
 
 // WriteAll writes the entire content of a file at the specified path.
 func (s *Storage) WriteAll(ctx context.Context, path string, bs []byte) error {
-	return s.bucket.WriteAll(ctx, path, bs, nil)
+	return s.WriteWith(ctx, path, bs, &WriteOptions{})
+}
+
+// WriteOptions are options for writing a file (see WriteWith).
+type WriteOptions struct {
+	// Precondition, if set, is the condition for the write to happen
+	// (see WriteIf).
+	Precondition *Precondition
+
+	// ContentType and ContentEncoding are recorded in the metadata of the
+	// file, if set. The content type is otherwise detected from the content.
+	ContentType     string
+	ContentEncoding string
+}
+
+// WriteWith writes the entire content of a file at the specified path,
+// with options.
+func (s *Storage) WriteWith(ctx context.Context, path string, bs []byte, opts *WriteOptions) error {
+	blobOpts := &blob.WriterOptions{
+		ContentType:     opts.ContentType,
+		ContentEncoding: opts.ContentEncoding,
+	}
+	if opts.Precondition != nil {
+		return s.writeIf(ctx, path, bs, *opts.Precondition, blobOpts)
+	}
+	return s.bucket.WriteAll(ctx, path, bs, blobOpts)
+}
+
+// Delete deletes the file at the specified path.
+// Returns [ErrNotExist] if the file does not exist.
+func (s *Storage) Delete(ctx context.Context, path string) error {
+	if err := s.bucket.Delete(ctx, path); err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			return ErrNotExist
+		}
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
 }
 
 // ObjectMetadata contains metadata about an object in the storage.
@@ -74,6 +129,10 @@ type ObjectMetadata struct {
 
 	// ContentLength is the size of the object in bytes.
 	ContentLength int64
+
+	// Generation identifies the current content of the object,
+	// for conditional writes (see WriteIf).
+	Generation string
 }
 
 // Stat inspects an object in the storage and returns its metadata.
@@ -90,6 +149,7 @@ func (s *Storage) Stat(ctx context.Context, path string) (*ObjectMetadata, error
 	return &ObjectMetadata{
 		Path:          path,
 		ContentLength: attrs.Size,
+		Generation:    generation(attrs),
 	}, nil
 }
 
@@ -114,15 +174,33 @@ func (s *Storage) ReadInto(ctx context.Context, path string, w io.Writer) (int64
 // ListObject is an object in a bucket list iteration.
 type ListObject struct {
 	Path string
+
+	// Size is the size of the object in bytes.
+	Size int64
+
+	// ModTime is when the object was last modified.
+	ModTime time.Time
 }
 
 // List returns an iterator over objects in the storage
 // with the specified prefix.
+// The objects are fetched from the storage a page at a time, as the
+// iteration proceeds.
 func (s *Storage) List(ctx context.Context, prefix string) iter.Seq2[ListObject, error] {
+	return s.list(ctx, prefix, "/")
+}
+
+// Walk is like List, but also returns the objects in subdirectories of
+// the prefix.
+func (s *Storage) Walk(ctx context.Context, prefix string) iter.Seq2[ListObject, error] {
+	return s.list(ctx, prefix, "")
+}
+
+func (s *Storage) list(ctx context.Context, prefix, delimiter string) iter.Seq2[ListObject, error] {
 	return func(yield func(ListObject, error) bool) {
 		it := s.bucket.List(&blob.ListOptions{
 			Prefix:    prefix,
-			Delimiter: "/",
+			Delimiter: delimiter,
 		})
 		for {
 			obj, err := it.Next(ctx)
@@ -137,7 +215,11 @@ func (s *Storage) List(ctx context.Context, prefix string) iter.Seq2[ListObject,
 				continue
 			}
 
-			listObj := ListObject{Path: obj.Key}
+			listObj := ListObject{
+				Path:    obj.Key,
+				Size:    obj.Size,
+				ModTime: obj.ModTime,
+			}
 			if !yield(listObj, nil) {
 				return
 			}
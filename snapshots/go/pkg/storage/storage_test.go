@@ -2,10 +2,16 @@ package storage
 
 import (
 	"bytes"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -40,6 +46,20 @@ func TestStat(t *testing.T) {
 	assert.Equal(t, int64(len(contents)), got.ContentLength)
 }
 
+func TestDelete(t *testing.T) {
+	storage, err := NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, storage.WriteAll(t.Context(), "test-file.txt", []byte("test content")))
+	require.NoError(t, storage.Delete(t.Context(), "test-file.txt"))
+
+	_, err = storage.Stat(t.Context(), "test-file.txt")
+	assert.ErrorIs(t, err, ErrNotExist)
+
+	err = storage.Delete(t.Context(), "test-file.txt")
+	assert.ErrorIs(t, err, ErrNotExist)
+}
+
 func TestErrNotFound(t *testing.T) {
 	storage, err := NewStorage("file://" + t.TempDir())
 	require.NoError(t, err)
@@ -119,6 +139,36 @@ func TestList(t *testing.T) {
 		}, got)
 	})
 
+	t.Run("Walk", func(t *testing.T) {
+		var got []string
+		for obj, err := range storage.Walk(t.Context(), "foo") {
+			require.NoError(t, err)
+			got = append(got, obj.Path)
+		}
+
+		assert.ElementsMatch(t, []string{
+			"foobar",
+			"foobaz",
+			"foo/bar",
+			"foo/qux",
+		}, got)
+	})
+
+	t.Run("Attributes", func(t *testing.T) {
+		require.NoError(t, storage.WriteAll(t.Context(), "attrs/file", []byte("hello")))
+
+		var got []ListObject
+		for obj, err := range storage.List(t.Context(), "attrs/") {
+			require.NoError(t, err)
+			got = append(got, obj)
+		}
+
+		require.Len(t, got, 1)
+		assert.Equal(t, "attrs/file", got[0].Path)
+		assert.Equal(t, int64(5), got[0].Size)
+		assert.WithinDuration(t, time.Now(), got[0].ModTime, time.Minute)
+	})
+
 	t.Run("PrefixDirFile", func(t *testing.T) {
 		var got []string
 		for obj, err := range storage.List(t.Context(), "foo/b") {
@@ -168,3 +218,145 @@ func TestS3SubdirectoryURL(t *testing.T) {
 	_ = store.WriteAll(t.Context(), "test.txt", []byte("hello"))
 	assert.Equal(t, "/mybucket/subdir/test.txt", capturedPath)
 }
+
+// Verifies that azblob://container/subdir URLs write to the subdir prefix,
+// with the credentials given in the URL.
+func TestAzureSubdirectoryURL(t *testing.T) {
+	var capturedPath, capturedSig, capturedAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedPath = r.URL.Path
+		capturedSig = r.URL.Query().Get("sig")
+		capturedAuth = r.Header.Get("Authorization")
+
+		// Return a minimal response for PUT requests.
+		if r.Method == http.MethodPut {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+
+		// Return Azure-style NotFound for anything else.
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	// With a local emulator, the account name is in the path, e.g.
+	//    127.0.0.1:10000/account-name/container/subdir
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	t.Run("SASToken", func(t *testing.T) {
+		sasToken := url.QueryEscape("sv=2020-08-04&sig=secret")
+		storageURL := fmt.Sprintf("azblob://mycontainer/subdir?domain=%s&protocol=http&localemu=true&storage_account=myaccount&sas_token=%s", host, sasToken)
+
+		store, err := NewStorage(storageURL)
+		require.NoError(t, err)
+
+		_ = store.WriteAll(t.Context(), "test.txt", []byte("hello"))
+		assert.Equal(t, "/myaccount/mycontainer/subdir/test.txt", capturedPath)
+		assert.Equal(t, "secret", capturedSig)
+	})
+
+	t.Run("ConnectionString", func(t *testing.T) {
+		connectionString := fmt.Sprintf("DefaultEndpointsProtocol=http;AccountName=myaccount;AccountKey=%s;BlobEndpoint=%s/myaccount;",
+			base64.StdEncoding.EncodeToString([]byte("key")), srv.URL)
+		storageURL := "azblob://mycontainer/subdir?connection_string=" + url.QueryEscape(connectionString)
+
+		store, err := NewStorage(storageURL)
+		require.NoError(t, err)
+
+		_, err = store.ReadAll(t.Context(), "missing.txt")
+		assert.ErrorIs(t, err, ErrNotExist)
+		assert.Equal(t, "/myaccount/mycontainer/subdir/missing.txt", capturedPath)
+		assert.True(t, strings.HasPrefix(capturedAuth, "SharedKey myaccount:"), capturedAuth)
+	})
+
+	t.Run("ConflictingCredentials", func(t *testing.T) {
+		_, err := NewStorage("azblob://mycontainer?storage_account=myaccount&sas_token=sig%3Dsecret&managed_identity=true")
+		assert.ErrorContains(t, err, "only one of")
+	})
+}
+
+func TestWriteIf(t *testing.T) {
+	storage, err := NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	t.Run("DoesNotExist", func(t *testing.T) {
+		cond := Precondition{DoesNotExist: true}
+		require.NoError(t, storage.WriteIf(t.Context(), "new.txt", []byte("first"), cond))
+
+		err := storage.WriteIf(t.Context(), "new.txt", []byte("second"), cond)
+		assert.ErrorIs(t, err, ErrPreconditionFailed)
+
+		got, err := storage.ReadAll(t.Context(), "new.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "first", string(got))
+	})
+
+	t.Run("Generation", func(t *testing.T) {
+		require.NoError(t, storage.WriteAll(t.Context(), "gen.txt", []byte("first")))
+		obj, err := storage.Stat(t.Context(), "gen.txt")
+		require.NoError(t, err)
+		require.NotEmpty(t, obj.Generation)
+
+		cond := Precondition{Generation: obj.Generation}
+		require.NoError(t, storage.WriteIf(t.Context(), "gen.txt", []byte("second"), cond))
+
+		// the generation is now stale
+		err = storage.WriteIf(t.Context(), "gen.txt", []byte("third"), cond)
+		assert.ErrorIs(t, err, ErrPreconditionFailed)
+
+		got, err := storage.ReadAll(t.Context(), "gen.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "second", string(got))
+	})
+
+	t.Run("GenerationNotExist", func(t *testing.T) {
+		err := storage.WriteIf(t.Context(), "missing.txt", []byte("first"), Precondition{Generation: "1"})
+		assert.ErrorIs(t, err, ErrPreconditionFailed)
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		var written atomic.Int32
+		for i := range 20 {
+			wg.Go(func() {
+				content := fmt.Appendf(nil, "writer %d", i)
+				err := storage.WriteIf(t.Context(), "concurrent.txt", content, Precondition{DoesNotExist: true})
+				if err == nil {
+					written.Add(1)
+				} else {
+					assert.ErrorIs(t, err, ErrPreconditionFailed)
+				}
+			})
+		}
+		wg.Wait()
+
+		// the lock makes the check and the write atomic
+		assert.Equal(t, int32(1), written.Load())
+	})
+}
+
+// Verifies that conditional writes to S3 send If-Match, and that rejected
+// writes are reported as ErrPreconditionFailed.
+func TestS3WriteIf(t *testing.T) {
+	var capturedIfMatch string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedIfMatch = r.Header.Get("If-Match")
+
+		w.WriteHeader(http.StatusPreconditionFailed)
+		_, _ = fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<Error>
+	<Code>PreconditionFailed</Code>
+	<Message>At least one of the pre-conditions you specified did not hold</Message>
+</Error>`)
+	}))
+	defer srv.Close()
+
+	storageURL := fmt.Sprintf("s3://mybucket?endpoint=%s&use_path_style=true&anonymous=true", srv.URL)
+	store, err := NewStorage(storageURL)
+	require.NoError(t, err)
+
+	err = store.WriteIf(t.Context(), "test.txt", []byte("hello"), Precondition{Generation: `"abc123"`})
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	assert.Equal(t, `"abc123"`, capturedIfMatch)
+}
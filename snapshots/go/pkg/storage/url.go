@@ -21,10 +21,10 @@ func transformURL(storageURL string) string {
 		u.Scheme = "gs"
 	}
 
-	// For cloud storage URLs (s3:// and gs://),
+	// For cloud storage URLs (s3://, gs:// and azblob://),
 	// the path component specifies a subdirectory prefix.
 	// gocloud.dev ignores the path, so we convert it to a ?prefix= query parameter.
-	if u.Scheme == "s3" || u.Scheme == "gs" {
+	if u.Scheme == "s3" || u.Scheme == "gs" || u.Scheme == "azblob" {
 		if path := strings.TrimPrefix(u.Path, "/"); path != "" {
 			// Ensure prefix ends with "/" for proper subdirectory behavior.
 			if !strings.HasSuffix(path, "/") {
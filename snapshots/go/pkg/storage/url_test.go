@@ -35,6 +35,11 @@ func TestTransformURL(t *testing.T) {
 			give: "s3://bucket-name/subdir",
 			want: "s3://bucket-name?prefix=subdir%2F",
 		},
+		{
+			name: "azblob with subdir",
+			give: "azblob://container-name/subdir",
+			want: "azblob://container-name?prefix=subdir%2F",
+		},
 
 		// No subdir means no prefix.
 		{
@@ -47,6 +52,11 @@ func TestTransformURL(t *testing.T) {
 			give: "s3://bucket-name",
 			want: "s3://bucket-name",
 		},
+		{
+			name: "azblob without subdir",
+			give: "azblob://container-name",
+			want: "azblob://container-name",
+		},
 
 		// Existing query parameters are preserved.
 		{
@@ -54,6 +64,11 @@ func TestTransformURL(t *testing.T) {
 			give: "s3://bucket-name/subdir?region=us-west-2",
 			want: "s3://bucket-name?prefix=subdir%2F&region=us-west-2",
 		},
+		{
+			name: "azblob with subdir and query params",
+			give: "azblob://container-name/subdir?storage_account=account",
+			want: "azblob://container-name?prefix=subdir%2F&storage_account=account",
+		},
 
 		// gcs:// is converted to gs://.
 		{
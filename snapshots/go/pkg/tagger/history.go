@@ -0,0 +1,112 @@
+package tagger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+)
+
+// HistoryStorage is the storage needed to read the history of tags.
+type HistoryStorage interface {
+	ReadAll(ctx context.Context, path string) ([]byte, error)
+	List(ctx context.Context, prefix string) iter.Seq2[storage.ListObject, error]
+}
+
+var _ HistoryStorage = (*storage.Storage)(nil)
+
+// historyPrefix is where the history of a tag is stored, with an object per
+// entry, so the history is only ever appended to.
+func historyPrefix(tagName string) string {
+	return fmt.Sprintf("tag-history/%s/", tagName)
+}
+
+// historyPath returns the path of a history entry, which sorts by time.
+func historyPath(entry *models.TagHistoryEntry) string {
+	return fmt.Sprintf("%s%020d.json", historyPrefix(entry.Tag), entry.Time.UnixNano())
+}
+
+// History returns the history of a tag, newest first.
+// Tags which haven't been moved since the history was introduced have no
+// history.
+func History(ctx context.Context, store HistoryStorage, tagName string) ([]models.TagHistoryEntry, error) {
+	prefix := historyPrefix(tagName)
+
+	var history []models.TagHistoryEntry
+	for obj, err := range store.List(ctx, prefix) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list history of tag %s: %w", tagName, err)
+		}
+
+		// skip the history of tags nested under this one, like <tag>/foo
+		if strings.Contains(strings.TrimPrefix(obj.Path, prefix), "/") {
+			continue
+		}
+
+		b, err := store.ReadAll(ctx, obj.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read history of tag %s: %w", tagName, err)
+		}
+
+		var entry models.TagHistoryEntry
+		if err := json.Unmarshal(b, &entry); err != nil {
+			return nil, fmt.Errorf("invalid history entry %s: %w", obj.Path, err)
+		}
+		history = append(history, entry)
+	}
+
+	slices.SortStableFunc(history, func(a, b models.TagHistoryEntry) int {
+		return b.Time.Compare(a.Time)
+	})
+	return history, nil
+}
+
+// HistoryOutputJSON writes the history of a tag as JSON.
+func HistoryOutputJSON(dest io.Writer, history []models.TagHistoryEntry) error {
+	if history == nil {
+		history = []models.TagHistoryEntry{}
+	}
+
+	out, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal history: %w", err)
+	}
+
+	_, err = io.Copy(dest, bytes.NewReader(out))
+	return err
+}
+
+// HistoryOutputPretty writes the history of a tag as a human-readable table,
+// where @{n} is the reference to the snapshot the tag pointed at before.
+func HistoryOutputPretty(dest io.Writer, history []models.TagHistoryEntry) error {
+	table := tablewriter.NewWriter(dest)
+	table.Header([]string{"Ref", "Time", "Snapshot", "Previous", "Actor", "Message"})
+	for i, entry := range history {
+		snapshot := entry.Snapshot
+		if snapshot == "" {
+			snapshot = "(deleted)"
+		}
+
+		table.Append([]string{
+			fmt.Sprintf("@{%d}", i),
+			entry.Time.Local().Format(time.DateTime),
+			snapshot,
+			entry.Previous,
+			entry.Actor,
+			entry.Message,
+		})
+	}
+	table.Render()
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package tagger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
+)
+
+// policyLocation is where the tag policy is stored. Without a policy, no
+// tags are protected.
+const policyLocation = "tag-policy.json"
+
+// ErrTagProtected is returned when moving or deleting a protected tag
+// without force (see models.TagPolicy).
+var ErrTagProtected = errors.New("tag is protected")
+
+// checkPolicy checks that the tag policy allows actor to move or delete a
+// tag.
+func (t *tagger) checkPolicy(ctx context.Context, tagName, actor string, force bool) error {
+	if force {
+		return nil
+	}
+
+	b, err := t.store.ReadAll(ctx, policyLocation)
+	if errors.Is(err, storage.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read tag policy: %w", err)
+	}
+
+	var policy models.TagPolicy
+	if err := json.Unmarshal(b, &policy); err != nil {
+		return fmt.Errorf("invalid tag policy %s: %w", policyLocation, err)
+	}
+
+	for _, protected := range policy.Protected {
+		match, err := path.Match(protected.Pattern, tagName)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q in tag policy: %w", protected.Pattern, err)
+		}
+		if match && !slices.Contains(protected.Actors, actor) {
+			return fmt.Errorf("%w: %s matches %q in the tag policy; use --force to change it anyway",
+				ErrTagProtected, tagName, protected.Pattern)
+		}
+	}
+
+	return nil
+}
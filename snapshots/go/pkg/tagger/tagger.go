@@ -2,47 +2,121 @@ package tagger
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"path"
-	"strings"
+	"iter"
+	"time"
 
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/snapshotfile"
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
 )
 
 type Storage interface {
+	ReadAll(ctx context.Context, location string) ([]byte, error)
 	WriteAll(ctx context.Context, location string, data []byte) error
+	WriteIf(ctx context.Context, location string, data []byte, cond storage.Precondition) error
+	Delete(ctx context.Context, location string) error
 	Stat(ctx context.Context, location string) (*storage.ObjectMetadata, error)
+	List(ctx context.Context, prefix string) iter.Seq2[storage.ListObject, error]
 }
 
 var _ Storage = (*storage.Storage)(nil)
 
+// ErrTagMoved is returned when a tag no longer points at the expected
+// snapshot (see TagArgs.Expect).
+var ErrTagMoved = errors.New("tag was moved")
+
 type tagger struct {
 	store Storage
+	now   func() time.Time
 }
 
 func NewTagger(store Storage) *tagger {
-	return &tagger{store: store}
+	return &tagger{store: store, now: time.Now}
 }
 
 type TagArgs struct {
 	SnapshotName string
 	TagName      string
+
+	// Actor and Message are recorded in the tag's history.
+	// The actor may also be allowed to move protected tags.
+	Actor   string
+	Message string
+
+	// Force moves the tag even if it's protected (see models.TagPolicy).
+	Force bool
+
+	// Expect, if set, is the snapshot the tag must currently point at,
+	// where "" means that the tag must not exist. The tag is then only
+	// moved if it still points there, atomically, or else ErrTagMoved is
+	// returned. This prevents concurrent pipelines from overwriting each
+	// other's tags.
+	Expect *string
 }
 
+// Tag points a tag at a snapshot, and records the change in the tag's
+// history (see History).
 func (t *tagger) Tag(ctx context.Context, args *TagArgs) (*storage.ObjectMetadata, error) {
-	snapshotLocation := fmt.Sprintf("snapshots/%s.json", args.SnapshotName)
+	if err := t.checkPolicy(ctx, args.TagName, args.Actor, args.Force); err != nil {
+		return nil, err
+	}
 
-	attrs, err := t.store.Stat(ctx, snapshotLocation)
+	snapshotLocation, err := snapshotfile.Find(ctx, t.store, args.SnapshotName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get snapshot: %w", err)
 	}
+	snapshotName, _ := snapshotfile.Name(snapshotLocation)
 
-	tagContent := []byte(strings.TrimSuffix(path.Base(attrs.Path), ".json"))
 	tagLocation := fmt.Sprintf("tags/%s", args.TagName)
-	if err := t.store.WriteAll(ctx, tagLocation, tagContent); err != nil {
+
+	// The generation is read before the tag, so if the tag is moved in
+	// between, the conditional write below fails.
+	cond := storage.Precondition{DoesNotExist: true}
+	if args.Expect != nil {
+		tagAttrs, err := t.store.Stat(ctx, tagLocation)
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return nil, fmt.Errorf("failed to get tag: %w", err)
+		}
+		if err == nil {
+			cond = storage.Precondition{Generation: tagAttrs.Generation}
+		}
+	}
+
+	previous, err := t.store.ReadAll(ctx, tagLocation)
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read tag: %w", err)
+	}
+
+	if args.Expect != nil {
+		if string(previous) != *args.Expect {
+			return nil, tagMovedError(args.TagName, string(previous), *args.Expect)
+		}
+
+		err := t.store.WriteIf(ctx, tagLocation, []byte(snapshotName), cond)
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			return nil, fmt.Errorf("%w: tag %s was moved while tagging", ErrTagMoved, args.TagName)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to write tag: %w", err)
+		}
+	} else if err := t.store.WriteAll(ctx, tagLocation, []byte(snapshotName)); err != nil {
 		return nil, fmt.Errorf("failed to write tag: %w", err)
 	}
 
+	err = t.record(ctx, &models.TagHistoryEntry{
+		Tag:      args.TagName,
+		Previous: string(previous),
+		Snapshot: snapshotName,
+		Actor:    args.Actor,
+		Message:  args.Message,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("tag was written, but %w", err)
+	}
+
 	obj, err := t.store.Stat(ctx, tagLocation)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get object details: %w", err)
@@ -50,3 +124,72 @@ func (t *tagger) Tag(ctx context.Context, args *TagArgs) (*storage.ObjectMetadat
 
 	return obj, nil
 }
+
+type DeleteArgs struct {
+	TagName string
+
+	// Actor and Message are recorded in the tag's history.
+	// The actor may also be allowed to delete protected tags.
+	Actor   string
+	Message string
+
+	// Force deletes the tag even if it's protected (see models.TagPolicy).
+	Force bool
+}
+
+// Delete deletes a tag, and records the deletion in the tag's history.
+// Returns the snapshot the tag pointed at.
+func (t *tagger) Delete(ctx context.Context, args *DeleteArgs) (string, error) {
+	if err := t.checkPolicy(ctx, args.TagName, args.Actor, args.Force); err != nil {
+		return "", err
+	}
+
+	tagLocation := fmt.Sprintf("tags/%s", args.TagName)
+	previous, err := t.store.ReadAll(ctx, tagLocation)
+	if errors.Is(err, storage.ErrNotExist) {
+		return "", fmt.Errorf("tag %s does not exist", args.TagName)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read tag: %w", err)
+	}
+
+	if err := t.store.Delete(ctx, tagLocation); err != nil {
+		return "", fmt.Errorf("failed to delete tag: %w", err)
+	}
+
+	err = t.record(ctx, &models.TagHistoryEntry{
+		Tag:      args.TagName,
+		Previous: string(previous),
+		Actor:    args.Actor,
+		Message:  args.Message,
+	})
+	if err != nil {
+		return "", fmt.Errorf("tag was deleted, but %w", err)
+	}
+
+	return string(previous), nil
+}
+
+// record adds an entry to the history of a tag, at the current time.
+func (t *tagger) record(ctx context.Context, entry *models.TagHistoryEntry) error {
+	entry.Time = t.now().UTC()
+
+	entryBytes, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history entry: %w", err)
+	}
+	if err := t.store.WriteAll(ctx, historyPath(entry), entryBytes); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return nil
+}
+
+func tagMovedError(tagName, actual, expected string) error {
+	switch {
+	case actual == "":
+		return fmt.Errorf("%w: tag %s does not exist, expected it to point at %s", ErrTagMoved, tagName, expected)
+	case expected == "":
+		return fmt.Errorf("%w: tag %s points at %s, expected it to not exist", ErrTagMoved, tagName, actual)
+	}
+	return fmt.Errorf("%w: tag %s points at %s, expected %s", ErrTagMoved, tagName, actual, expected)
+}
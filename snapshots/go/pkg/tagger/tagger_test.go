@@ -2,7 +2,9 @@ package tagger
 
 import (
 	"testing"
+	"time"
 
+	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/models"
 	"github.com/cognitedata/bazel-snapshots/snapshots/go/pkg/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -28,3 +30,163 @@ func TestTag(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, "foo", string(gotTag))
 }
+
+func TestTagHistory(t *testing.T) {
+	store, err := storage.NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	for _, name := range []string{"foo", "bar"} {
+		require.NoError(t, store.WriteAll(
+			t.Context(), "snapshots/"+name+".json", []byte(`{"test":"data"}`)))
+	}
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tagger := NewTagger(store)
+	tagger.now = func() time.Time { return start }
+
+	_, err = tagger.Tag(t.Context(), &TagArgs{SnapshotName: "foo", TagName: "deployed", Actor: "alice"})
+	require.NoError(t, err)
+
+	tagger.now = func() time.Time { return start.Add(time.Hour) }
+	_, err = tagger.Tag(t.Context(), &TagArgs{SnapshotName: "bar", TagName: "deployed", Actor: "bob", Message: "release"})
+	require.NoError(t, err)
+
+	// a nested tag's history must not show up in its parent's
+	require.NoError(t, store.WriteAll(t.Context(), historyPath(&models.TagHistoryEntry{
+		Tag:  "deployed/eu",
+		Time: start,
+	}), []byte(`{"tag":"deployed/eu","snapshot":"foo"}`)))
+
+	history, err := History(t.Context(), store, "deployed")
+	require.NoError(t, err)
+	assert.Equal(t, []models.TagHistoryEntry{
+		{
+			Tag:      "deployed",
+			Previous: "foo",
+			Snapshot: "bar",
+			Time:     start.Add(time.Hour),
+			Actor:    "bob",
+			Message:  "release",
+		},
+		{
+			Tag:      "deployed",
+			Snapshot: "foo",
+			Time:     start,
+			Actor:    "alice",
+		},
+	}, history)
+
+	history, err = History(t.Context(), store, "nonexistent")
+	require.NoError(t, err)
+	assert.Empty(t, history)
+}
+
+func TestTagExpect(t *testing.T) {
+	store, err := storage.NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	for _, name := range []string{"foo", "bar"} {
+		require.NoError(t, store.WriteAll(
+			t.Context(), "snapshots/"+name+".json", []byte(`{"test":"data"}`)))
+	}
+
+	tagger := NewTagger(store)
+	tag := func(snapshotName, expect string) error {
+		_, err := tagger.Tag(t.Context(), &TagArgs{
+			SnapshotName: snapshotName,
+			TagName:      "deployed",
+			Expect:       &expect,
+		})
+		return err
+	}
+	assertTag := func(want string) {
+		t.Helper()
+		got, err := store.ReadAll(t.Context(), "tags/deployed")
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+	}
+
+	// "" expects the tag not to exist
+	require.NoError(t, tag("foo", ""))
+	assertTag("foo")
+
+	err = tag("bar", "")
+	assert.ErrorIs(t, err, ErrTagMoved)
+	assert.ErrorContains(t, err, "points at foo, expected it to not exist")
+
+	err = tag("bar", "bar")
+	assert.ErrorIs(t, err, ErrTagMoved)
+	assertTag("foo")
+
+	require.NoError(t, tag("bar", "foo"))
+	assertTag("bar")
+}
+
+func TestDelete(t *testing.T) {
+	store, err := storage.NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.WriteAll(
+		t.Context(), "snapshots/foo.json", []byte(`{"test":"data"}`)))
+
+	tagger := NewTagger(store)
+	_, err = tagger.Tag(t.Context(), &TagArgs{SnapshotName: "foo", TagName: "deployed"})
+	require.NoError(t, err)
+
+	previous, err := tagger.Delete(t.Context(), &DeleteArgs{TagName: "deployed", Actor: "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, "foo", previous)
+
+	_, err = store.ReadAll(t.Context(), "tags/deployed")
+	assert.ErrorIs(t, err, storage.ErrNotExist)
+
+	history, err := History(t.Context(), store, "deployed")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "foo", history[0].Previous)
+	assert.Empty(t, history[0].Snapshot)
+	assert.Equal(t, "alice", history[0].Actor)
+
+	_, err = tagger.Delete(t.Context(), &DeleteArgs{TagName: "deployed"})
+	assert.ErrorContains(t, err, "does not exist")
+}
+
+func TestTagPolicy(t *testing.T) {
+	store, err := storage.NewStorage("file://" + t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, store.WriteAll(
+		t.Context(), "snapshots/foo.json", []byte(`{"test":"data"}`)))
+	require.NoError(t, store.WriteAll(t.Context(), "tag-policy.json", []byte(`{
+		"protected": [
+			{"pattern": "production", "actors": ["ci"]},
+			{"pattern": "release-*"}
+		]
+	}`)))
+
+	tagger := NewTagger(store)
+	tag := func(tagName, actor string, force bool) error {
+		_, err := tagger.Tag(t.Context(), &TagArgs{
+			SnapshotName: "foo",
+			TagName:      tagName,
+			Actor:        actor,
+			Force:        force,
+		})
+		return err
+	}
+
+	require.NoError(t, tag("staging", "alice", false))
+	require.NoError(t, tag("production", "ci", false))
+
+	err = tag("production", "alice", false)
+	assert.ErrorIs(t, err, ErrTagProtected)
+	err = tag("release-1", "ci", false)
+	assert.ErrorIs(t, err, ErrTagProtected)
+
+	require.NoError(t, tag("release-1", "alice", true))
+
+	_, err = tagger.Delete(t.Context(), &DeleteArgs{TagName: "release-1", Actor: "alice"})
+	assert.ErrorIs(t, err, ErrTagProtected)
+	_, err = tagger.Delete(t.Context(), &DeleteArgs{TagName: "release-1", Actor: "alice", Force: true})
+	require.NoError(t, err)
+}